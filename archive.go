@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Zip archives.
+
+ROMs inside a zip archive are loaded with the path of the archive followed
+by the name of the file inside it, like games.zip/PONG or
+games.zip/chip8/PONG.ch8. Only the base name is needed when no other ROM of
+the archive has it. Loading the archive alone works when it has one ROM,
+the error lists the ROMs otherwise.
+
+An archive can also be used as a library: -romdir games.zip lists its ROMs
+in the menu, archives inside -romdir are opened from the menu, and dropping
+an archive with several ROMs on the window opens it in the menu.
+*/
+
+// Largest file read from an archive, Octo sources and cartridges can be
+// much larger than the ROM
+const maxArchiveEntrySize = 16 << 20
+
+// splitArchivePath splits a path like games.zip/PONG into the archive and
+// the file inside it, the file is empty for the archive alone.
+func splitArchivePath(path string) (archive, entry string, ok bool) {
+	lower := strings.ToLower(path)
+	for _, sep := range []string{"/", string(filepath.Separator)} {
+		if i := strings.Index(lower, ".zip"+sep); i >= 0 {
+			return path[:i+4], path[i+5:], true
+		}
+	}
+	if strings.HasSuffix(lower, ".zip") {
+		return path, "", true
+	}
+	return "", "", false
+}
+
+// romFile returns the file on disk that has the ROM at path, the archive
+// for ROMs inside one.
+func romFile(path string) string {
+	if archive, _, ok := splitArchivePath(path); ok {
+		return archive
+	}
+	return path
+}
+
+// archiveFiles returns the files of an archive, without directories and
+// hidden files.
+func archiveFiles(r *zip.Reader) []*zip.File {
+	var files []*zip.File
+	for _, f := range r.File {
+		name := filepath.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// archiveROMs returns the files of an archive listed as ROMs.
+func archiveROMs(r *zip.Reader) []*zip.File {
+	var roms []*zip.File
+	for _, f := range archiveFiles(r) {
+		if isROMFile(f.Name) {
+			roms = append(roms, f)
+		}
+	}
+	return roms
+}
+
+// findArchiveROM picks the file named entry, or the only ROM of the archive
+// when entry is empty.
+func findArchiveROM(archive, entry string, roms []*zip.File) (*zip.File, error) {
+	if entry == "" {
+		switch len(roms) {
+		case 0:
+			return nil, fmt.Errorf("%s has no CHIP-8 ROMs", filepath.Base(archive))
+		case 1:
+			return roms[0], nil
+		}
+		var names []string
+		for _, f := range roms {
+			names = append(names, f.Name)
+		}
+		return nil, fmt.Errorf("%s has %d ROMs, pick one with %s/NAME: %s", filepath.Base(archive), len(roms), archive, strings.Join(names, ", "))
+	}
+
+	entry = filepath.ToSlash(entry)
+	var found []*zip.File
+	for _, f := range roms {
+		if f.Name == entry {
+			return f, nil
+		}
+		if strings.EqualFold(filepath.Base(f.Name), entry) {
+			found = append(found, f)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%s has no ROM named %s", filepath.Base(archive), entry)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%s has %d ROMs named %s, use the full name", filepath.Base(archive), len(found), entry)
+}
+
+func readArchiveFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxArchiveEntrySize {
+		return nil, fmt.Errorf("%s is %d bytes, too large for a ROM", f.Name, f.UncompressedSize64)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, maxArchiveEntrySize))
+}
+
+// readArchiveROM reads a ROM inside an archive. The file is extracted with
+// its own name to a temporary directory, so Octo sources and cartridges are
+// read the same as outside an archive.
+func readArchiveROM(path string) ([]byte, *cartridge, error) {
+	archive, entry, _ := splitArchivePath(path)
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	// A file picked by name is loaded whatever its extension, like outside
+	// an archive
+	files := archiveROMs(&r.Reader)
+	if entry != "" {
+		files = archiveFiles(&r.Reader)
+	}
+	f, err := findArchiveROM(archive, entry, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := readArchiveFile(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "c8-zip-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	extracted := filepath.Join(dir, filepath.Base(f.Name))
+	if err := os.WriteFile(extracted, data, 0o644); err != nil {
+		return nil, nil, err
+	}
+	return readROMFile(extracted)
+}
+
+// listArchive returns the ROMs of an archive for the menu.
+func (l *romLoader) listArchive(archive string) ([]romEntry, error) {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var roms []romEntry
+	for _, f := range archiveROMs(&r.Reader) {
+		data, err := readArchiveFile(f)
+		if err != nil || !isListedROM(f.Name, data) {
+			continue
+		}
+		roms = append(roms, romEntry{path: archive + "/" + f.Name, title: l.title(data, f.Name)})
+	}
+	return roms, nil
+}
+
+// archiveEntry is the menu entry of an archive inside the ROM directory,
+// the ROM itself when it has only one.
+func (l *romLoader) archiveEntry(archive string) romEntry {
+	entry := romEntry{path: archive, title: filepath.Base(archive) + "/", library: true}
+	roms, err := l.listArchive(archive)
+	if err == nil && len(roms) == 1 {
+		entry = roms[0]
+	}
+	return entry
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListArchiveSkipsPictures(t *testing.T) {
+	dir := t.TempDir()
+	cart := filepath.Join(dir, "cart.gif")
+	pal := palette{background: color.RGBA{A: 0xFF}, foreground: color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}}
+	if err := writeCartridge(cart, "Cart", []byte{0x12, 0x00}, cartridgeOptions{}, pal); err != nil {
+		t.Fatal(err)
+	}
+	cartData, err := os.ReadFile(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var picture bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&picture, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "games.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, data := range map[string][]byte{
+		"pong.ch8":          {0x12, 0x00},
+		"cart.gif":          cartData,
+		"screenshot.gif":    picture.Bytes(),
+		"README":            []byte("Games\n"),
+		"__MACOSX/pong.ch8": {0x12, 0x00},
+	} {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err := loadROMDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roms, err := (&romLoader{db: db}).listROMs(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]bool{}
+	for _, rom := range roms {
+		listed[rom.path] = true
+	}
+	want := map[string]bool{archive + "/cart.gif": true, archive + "/pong.ch8": true}
+	if len(listed) != len(want) {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+	for path := range want {
+		if !listed[path] {
+			t.Errorf("%s not listed", path)
+		}
+	}
+}
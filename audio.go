@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+)
+
+/*
+Buzzer audio.
+
+The beeper generates a tone while the sound timer is running and sends the
+samples (16 bit signed, mono) to one or more sinks: an external player that
+reads raw PCM from stdin, or a WAV file. The WAV sink lets sound be captured
+and checked on machines without audio hardware.
+
+The volume ramps up and down over a few milliseconds when the buzzer starts
+and stops, cutting a wave in the middle is heard as a click.
+*/
+
+const (
+	audioSampleRate = 44100
+	audioRamp       = 0.005 // Seconds to go from silence to full volume
+)
+
+var waveforms = map[string]func(phase float64) float64{
+	"square": func(p float64) float64 {
+		if p < 0.5 {
+			return 1
+		}
+		return -1
+	},
+	"triangle": func(p float64) float64 { return 4*math.Abs(p-0.5) - 1 },
+	"sawtooth": func(p float64) float64 { return 2*p - 1 },
+	"sine":     func(p float64) float64 { return math.Sin(2 * math.Pi * p) },
+}
+
+type toneGenerator struct {
+	wave      func(phase float64) float64
+	frequency float64 // Hz
+	volume    float64 // 0-1
+	phase     float64 // Position in the current period (0-1)
+	level     float64 // Current envelope (0-1)
+	pending   float64 // Fraction of sample carried to the next frame
+}
+
+func newToneGenerator(waveform string, frequency, volume float64) (*toneGenerator, error) {
+	wave, ok := waveforms[waveform]
+	if !ok {
+		return nil, fmt.Errorf("unknown waveform %q (use square, triangle, sawtooth or sine)", waveform)
+	}
+	if frequency <= 0 || frequency >= audioSampleRate/2 {
+		return nil, fmt.Errorf("tone frequency must be between 0 and %d Hz", audioSampleRate/2)
+	}
+	if volume < 0 || volume > 1 {
+		return nil, fmt.Errorf("volume must be between 0 and 1")
+	}
+	return &toneGenerator{wave: wave, frequency: frequency, volume: volume}, nil
+}
+
+// frame returns the samples of one 60 Hz frame.
+func (t *toneGenerator) frame(active bool) []int16 {
+	t.pending += audioSampleRate / 60.0
+	n := int(t.pending)
+	t.pending -= float64(n)
+
+	target := 0.0
+	if active {
+		target = 1
+	}
+	step := 1 / (audioRamp * audioSampleRate)
+
+	samples := make([]int16, n)
+	for i := range samples {
+		if t.level < target {
+			t.level = math.Min(target, t.level+step)
+		} else if t.level > target {
+			t.level = math.Max(target, t.level-step)
+		}
+		if t.level == 0 {
+			// Restart the wave from zero, the next beep starts cleanly
+			t.phase = 0
+			continue
+		}
+		samples[i] = int16(t.wave(t.phase) * t.level * t.volume * math.MaxInt16)
+		t.phase += t.frequency / audioSampleRate
+		t.phase -= math.Floor(t.phase)
+	}
+	return samples
+}
+
+// audioSink receives the generated samples.
+type audioSink interface {
+	write(samples []int16) error
+	close() error
+}
+
+// beeper is the Audio backend that plays the buzzer with a toneGenerator.
+type beeper struct {
+	tone  *toneGenerator
+	sinks []audioSink
+}
+
+func (b *beeper) Update(active bool) {
+	samples := b.tone.frame(active)
+	sinks := b.sinks[:0]
+	for _, s := range b.sinks {
+		if err := s.write(samples); err != nil {
+			fmt.Printf("Audio output stopped: %v\n", err)
+			s.close()
+			continue
+		}
+		sinks = append(sinks, s)
+	}
+	b.sinks = sinks
+}
+
+func (b *beeper) Close() {
+	for _, s := range b.sinks {
+		if err := s.close(); err != nil {
+			fmt.Printf("Error closing audio output: %v\n", err)
+		}
+	}
+	b.sinks = nil
+}
+
+// wavSink writes the samples to a WAV file. The sizes in the header are
+// written when the file is closed.
+type wavSink struct {
+	f       *os.File
+	samples uint32
+}
+
+func newWAVSink(path string) (*wavSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavSink{f: f}
+	if err := w.writeHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wavSink) writeHeader() error {
+	dataSize := w.samples * 2
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'}, 36 + dataSize, [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16),
+		uint16(1),                   // PCM
+		uint16(1),                   // Mono
+		uint32(audioSampleRate),     // Sample rate
+		uint32(audioSampleRate * 2), // Bytes per second
+		uint16(2),                   // Bytes per sample
+		uint16(16),                  // Bits per sample
+		[4]byte{'d', 'a', 't', 'a'}, dataSize,
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	for _, v := range header {
+		if err := binary.Write(w.f, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	_, err := w.f.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (w *wavSink) write(samples []int16) error {
+	w.samples += uint32(len(samples))
+	return binary.Write(w.f, binary.LittleEndian, samples)
+}
+
+func (w *wavSink) close() error {
+	if err := w.writeHeader(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// Players that read raw 16 bit mono PCM from stdin, tried in order.
+var audioPlayers = [][]string{
+	{"aplay", "-q", "-t", "raw", "-f", "S16_LE", "-c", "1", "-r", fmt.Sprint(audioSampleRate), "-"},
+	{"pacat", "--playback", "--format=s16le", "--channels=1", fmt.Sprintf("--rate=%d", audioSampleRate)},
+	{"ffplay", "-nodisp", "-loglevel", "quiet", "-f", "s16le", "-ch_layout", "mono", "-ar", fmt.Sprint(audioSampleRate), "-"},
+}
+
+// playerSink streams the samples to an external audio player.
+type playerSink struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func newPlayerSink() (*playerSink, error) {
+	for _, args := range audioPlayers {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			continue
+		}
+		return &playerSink{cmd: cmd, stdin: stdin}, nil
+	}
+	return nil, fmt.Errorf("no audio player found (aplay, pacat or ffplay)")
+}
+
+func (p *playerSink) write(samples []int16) error {
+	return binary.Write(p.stdin, binary.LittleEndian, samples)
+}
+
+func (p *playerSink) close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// newAudio builds the Audio backend for the -audio mode: auto (a player if
+// one is installed, BEEP! on stdout otherwise), player, console or none.
+// With a WAV path the buzzer is also written to that file.
+func newAudio(mode, wavPath string, tone *toneGenerator) (Audio, error) {
+	b := &beeper{tone: tone}
+	switch mode {
+	case "auto", "player":
+		p, err := newPlayerSink()
+		if err == nil {
+			b.sinks = append(b.sinks, p)
+		} else if mode == "player" {
+			return nil, err
+		}
+	case "console", "none":
+	default:
+		return nil, fmt.Errorf("unknown audio mode %q (use auto, player, console or none)", mode)
+	}
+
+	if wavPath != "" {
+		w, err := newWAVSink(wavPath)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.sinks = append(b.sinks, w)
+	}
+
+	if len(b.sinks) == 0 && mode != "none" {
+		return &consoleAudio{}, nil
+	}
+	return b, nil
+}
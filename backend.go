@@ -0,0 +1,63 @@
+package main
+
+import "fmt"
+
+/*
+Backends.
+
+The emulation loop only talks to these interfaces, so the cpu doesn't know
+if it is drawn in a GLFW window, a terminal or nowhere at all. Tests and
+headless runs can plug fake implementations.
+*/
+
+// Display shows the frames produced by the cpu.
+type Display interface {
+	// Present is called once per frame with the current screen.
+	Present(gfx *[WIDTH * HEIGHT]byte)
+	// ShouldClose reports when the user closed the display.
+	ShouldClose() bool
+	Close()
+}
+
+// Input provides the state of the 16 keys of the keypad.
+type Input interface {
+	// Update is called before every frame and writes the keypad state,
+	// 1 for pressed and 0 for released.
+	Update(keys *[16]byte)
+}
+
+// Audio plays the buzzer of the CHIP-8.
+type Audio interface {
+	// Update is called once per frame, active is true while the sound
+	// timer is running.
+	Update(active bool)
+	Close()
+}
+
+// headlessDisplay discards every frame, used when running without a window.
+type headlessDisplay struct{}
+
+func (headlessDisplay) Present(gfx *[WIDTH * HEIGHT]byte) {}
+func (headlessDisplay) ShouldClose() bool                 { return false }
+func (headlessDisplay) Close()                            {}
+
+// nullInput never presses a key.
+type nullInput struct{}
+
+func (nullInput) Update(keys *[16]byte) {
+	*keys = [16]byte{}
+}
+
+// consoleAudio prints BEEP! on stdout every time the buzzer starts.
+type consoleAudio struct {
+	active bool
+}
+
+func (a *consoleAudio) Update(active bool) {
+	if active && !a.active {
+		fmt.Println("BEEP!")
+	}
+	a.active = active
+}
+
+func (a *consoleAudio) Close() {}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+Octo cartridges.
+
+Octo shares programs as GIF images with the program hidden in the pixels.
+The low 4 bits of the palette index of every pixel, over all the frames, are
+the nibbles of the payload (high nibble first). The payload starts with its
+length as 4 big endian bytes, followed by a JSON object:
+
+	{"program": "<Octo source>", "options": {"tickrate": 20, ...}}
+
+The program is Octo source. Programs that are only byte literals (like the
+exported ones) are read here, others need the octo command line tool, the
+same as .8o files. The options set the speed, quirks and colors of the ROM.
+
+F7 saves the current ROM and its settings as a cartridge, with the program
+written as byte literals.
+*/
+
+// cartridgeOptions are the options saved by Octo, the names are the ones of
+// its JSON.
+type cartridgeOptions struct {
+	Tickrate        int    `json:"tickrate"`
+	FillColor       string `json:"fillColor"`
+	FillColor2      string `json:"fillColor2"`
+	BlendColor      string `json:"blendColor"`
+	BackgroundColor string `json:"backgroundColor"`
+	BuzzColor       string `json:"buzzColor"`
+	QuietColor      string `json:"quietColor"`
+	ShiftQuirks     bool   `json:"shiftQuirks"`
+	LoadStoreQuirks bool   `json:"loadStoreQuirks"`
+	VFOrderQuirks   bool   `json:"vfOrderQuirks"`
+	ClipQuirks      bool   `json:"clipQuirks"`
+	VBlankQuirks    bool   `json:"vBlankQuirks"`
+	JumpQuirks      bool   `json:"jumpQuirks"`
+	LogicQuirks     bool   `json:"logicQuirks"`
+	MaxSize         int    `json:"maxSize"`
+	ScreenRotation  int    `json:"screenRotation"`
+	FontStyle       string `json:"fontStyle"`
+	TouchInputMode  string `json:"touchInputMode"`
+}
+
+type cartridge struct {
+	Program string           `json:"program"`
+	Options cartridgeOptions `json:"options"`
+}
+
+// Largest programs of the Octo presets, Octo picks the platform with them
+const (
+	octoMaxSizeChip8  = 3216
+	octoMaxSizeSchip  = 3583
+	octoMaxSizeXOChip = 65024
+)
+
+// readCartridge extracts the payload of a cartridge GIF.
+func readCartridge(path string) (*cartridge, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeCartridge(f, path)
+}
+
+// decodeCartridge extracts the payload of the cartridge GIF read from r,
+// path is only used in the errors.
+func decodeCartridge(r io.Reader, path string) (*cartridge, error) {
+	img, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a GIF: %v", filepath.Base(path), err)
+	}
+
+	var payload []byte
+	var high byte
+	nibbles := 0
+	for _, frame := range img.Image {
+		for _, index := range frame.Pix {
+			if nibbles%2 == 0 {
+				high = index & 0xF
+			} else {
+				payload = append(payload, high<<4|index&0xF)
+			}
+			nibbles++
+		}
+	}
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("%s is too small to be an Octo cartridge", filepath.Base(path))
+	}
+	size := int(payload[0])<<24 | int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+	if size <= 0 || size > len(payload)-4 {
+		return nil, fmt.Errorf("%s is not an Octo cartridge (payload of %d bytes)", filepath.Base(path), size)
+	}
+	cart := &cartridge{}
+	if err := json.Unmarshal(payload[4:4+size], cart); err != nil {
+		return nil, fmt.Errorf("%s is not an Octo cartridge: %v", filepath.Base(path), err)
+	}
+	return cart, nil
+}
+
+// rom returns the bytes of the program of the cartridge.
+func (c *cartridge) rom(path string) ([]byte, error) {
+	if data, ok := parseByteLiterals(c.Program); ok {
+		return data, nil
+	}
+	// A real Octo program, the octo tool assembles it
+	src, err := os.CreateTemp("", "c8-*.8o")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(src.Name())
+	_, err = src.WriteString(c.Program)
+	src.Close()
+	if err != nil {
+		return nil, err
+	}
+	data, err := assembleOcto(src.Name())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return data, nil
+}
+
+// parseByteLiterals reads an Octo source made only of numbers, false if it
+// has anything else.
+func parseByteLiterals(src string) ([]byte, bool) {
+	var data []byte
+	for _, line := range strings.Split(src, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		for _, token := range strings.Fields(line) {
+			base := 10
+			switch {
+			case strings.HasPrefix(token, "0x"), strings.HasPrefix(token, "0X"):
+				token, base = token[2:], 16
+			case strings.HasPrefix(token, "0b"), strings.HasPrefix(token, "0B"):
+				token, base = token[2:], 2
+			}
+			v, err := strconv.ParseUint(token, base, 8)
+			if err != nil {
+				return nil, false
+			}
+			data = append(data, byte(v))
+		}
+	}
+	return data, len(data) > 0
+}
+
+// profile turns the options into the setup of the ROM.
+func (o cartridgeOptions) profile(db *romDatabase) romProfile {
+	id := "modernChip8"
+	switch {
+	case o.MaxSize > octoMaxSizeSchip:
+		id = "xochip"
+	case o.MaxSize > octoMaxSizeChip8:
+		id = "superchip"
+	}
+	p, _ := db.platform(id)
+	profile := romProfile{platform: p, quirks: p.Quirks, tickrate: p.DefaultTickrate, source: "Octo cartridge"}
+	profile.quirks.Shift = o.ShiftQuirks
+	profile.quirks.MemoryLeaveIUnchanged = o.LoadStoreQuirks
+	profile.quirks.MemoryIncrementByX = false
+	profile.quirks.Wrap = !o.ClipQuirks
+	profile.quirks.VBlank = o.VBlankQuirks
+	profile.quirks.Jump = o.JumpQuirks
+	profile.quirks.Logic = o.LogicQuirks
+	if o.Tickrate > 0 {
+		profile.tickrate = o.Tickrate
+	}
+	if pal, err := parsePalette(o.BackgroundColor + "," + o.FillColor); err == nil {
+		profile.palette = &pal
+	}
+	return profile
+}
+
+// cartridgeOptionsOf returns the options that reproduce a setup in Octo.
+func cartridgeOptionsOf(platform string, q quirks, ipf int, pal palette) cartridgeOptions {
+	hex := func(c color.RGBA) string { return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B) }
+	o := cartridgeOptions{
+		Tickrate:        ipf,
+		FillColor:       hex(pal.foreground),
+		FillColor2:      hex(pal.foreground),
+		BlendColor:      hex(pal.foreground),
+		BackgroundColor: hex(pal.background),
+		BuzzColor:       hex(pal.foreground),
+		QuietColor:      hex(pal.background),
+		ShiftQuirks:     q.Shift,
+		LoadStoreQuirks: q.MemoryLeaveIUnchanged,
+		ClipQuirks:      !q.Wrap,
+		VBlankQuirks:    q.VBlank,
+		JumpQuirks:      q.Jump,
+		LogicQuirks:     q.Logic,
+		MaxSize:         octoMaxSizeChip8,
+		FontStyle:       "octo",
+		TouchInputMode:  "none",
+	}
+	switch platform {
+	case "xochip":
+		o.MaxSize = octoMaxSizeXOChip
+	case "superchip", "superchip1", "chip48":
+		o.MaxSize = octoMaxSizeSchip
+	}
+	return o
+}
+
+// Width of the exported cartridges, the label is drawn at the top
+const (
+	cartridgeWidth      = 128
+	cartridgeLabelScale = 2
+)
+
+// writeCartridge saves a ROM and its options as a cartridge GIF with the
+// title of the ROM written on it. It fails when path already exists.
+func writeCartridge(path, title string, rom []byte, options cartridgeOptions, pal palette) error {
+	var src strings.Builder
+	fmt.Fprintf(&src, "# %s, exported by project_c8\n", title)
+	for i, b := range rom {
+		sep := " "
+		if i%16 == 15 || i == len(rom)-1 {
+			sep = "\n"
+		}
+		fmt.Fprintf(&src, "0x%02X%s", b, sep)
+	}
+	body, err := json.Marshal(cartridge{Program: src.String(), Options: options})
+	if err != nil {
+		return err
+	}
+	payload := append([]byte{byte(len(body) >> 24), byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+
+	// The high nibble of the palette index is the picture, 0 for the
+	// background and 1 for the text, the low one the payload
+	labelHeight := (glyphHeight + 4) * cartridgeLabelScale
+	height := max(labelHeight, (2*len(payload)+cartridgeWidth-1)/cartridgeWidth)
+	colors := make(color.Palette, 32)
+	for i := range colors {
+		colors[i] = pal.background
+		if i >= 16 {
+			colors[i] = pal.foreground
+		}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, cartridgeWidth, height), colors)
+	x := 2 * cartridgeLabelScale
+	for _, r := range strings.ToUpper(title) {
+		glyph, ok := osdFont[r]
+		if !ok || x+glyphWidth*cartridgeLabelScale > cartridgeWidth {
+			continue
+		}
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				for dy := 0; dy < cartridgeLabelScale; dy++ {
+					for dx := 0; dx < cartridgeLabelScale; dx++ {
+						img.SetColorIndex(x+col*cartridgeLabelScale+dx, 2*cartridgeLabelScale+row*cartridgeLabelScale+dy, 16)
+					}
+				}
+			}
+		}
+		x += (glyphWidth + 1) * cartridgeLabelScale
+	}
+	for i, b := range payload {
+		img.Pix[2*i] |= b >> 4
+		img.Pix[2*i+1] |= b & 0xF
+	}
+
+	// Never over another file, it could be the cartridge the ROM came from
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, &gif.GIF{Image: []*image.Paletted{img}, Delay: []int{0}}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+func TestCartridgeRoundTrip(t *testing.T) {
+	db, err := loadROMDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := db.platformByName("schip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pal := palette{background: color.RGBA{0x1A, 0x1C, 0x2C, 0xFF}, foreground: color.RGBA{0xF4, 0xF4, 0xF4, 0xFF}}
+	rom := bytes.Repeat([]byte{0x00, 0xE0, 0xA2, 0x2A, 0xFF, 0x12, 0x00}, 60)
+	options := cartridgeOptionsOf(p.ID, p.Quirks, 30, pal)
+
+	path := filepath.Join(t.TempDir(), "test.gif")
+	if err := writeCartridge(path, "Round trip", rom, options, pal); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCartridge(path, "Again", rom, options, pal); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("writing over the cartridge: error %v, want it to exist", err)
+	}
+	cart, err := readCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.Options != options {
+		t.Errorf("options %+v, want %+v", cart.Options, options)
+	}
+	data, err := cart.rom(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, rom) {
+		t.Errorf("program of %d bytes, want the %d bytes written", len(data), len(rom))
+	}
+
+	profile := cart.Options.profile(db)
+	if profile.platform.ID != "superchip" || profile.tickrate != 30 {
+		t.Errorf("platform %s at %d, want superchip at 30", profile.platform.ID, profile.tickrate)
+	}
+	if profile.palette == nil || *profile.palette != pal {
+		t.Errorf("palette %v, want %v", profile.palette, pal)
+	}
+}
+
+func TestParseByteLiterals(t *testing.T) {
+	data, ok := parseByteLiterals("# comment\n0x12 0X34 255\n0b101 # more\n")
+	if !ok || !bytes.Equal(data, []byte{0x12, 0x34, 0xFF, 0x05}) {
+		t.Errorf("got %X %v", data, ok)
+	}
+	for _, src := range []string{": main\n0x12", "256", "", "# only a comment"} {
+		if _, ok := parseByteLiterals(src); ok {
+			t.Errorf("%q read as byte literals", src)
+		}
+	}
+}
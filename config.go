@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+User configuration.
+
+Settings are kept in $XDG_CONFIG_HOME/project_c8/config.json (usually
+~/.config/project_c8/config.json, -config changes it):
+
+	{
+		"settings": {"scale": 8, "palette": "1A1C2C,F4F4F4", "volume": 0.5},
+		"keys": {"default": {"5": ["W", "Up"]}, "hotkeys": {"pause": ["Space"]}},
+		"roms": {
+			"<sha1 of the ROM>": {
+				"name": "PONG",
+				"platform": "schip", "ipf": 20, "palette": "000000,33FF66",
+				"keys": {"1": ["W"], "4": ["S"]}
+			}
+		}
+	}
+
+"settings" has the values of command line flags, by the name of the flag
+without the dash. "keys" has the same format as keys.json, and keys.json
+wins over it. A ROM section changes the platform, speed, colors and key
+bindings of one ROM, over what the ROM database says.
+
+Flags given on the command line win over the file. They are also saved in
+the file for the next runs, unless -save=false is given or the run is
+headless: -scale, -palette, -volume and -romdir in "settings", -platform and
+-ipf in the section of the ROM loaded at startup, as they depend on the
+game. The emulator writes the directory of the last ROM loaded as "romdir".
+
+The platform, speed and colors of a ROM come from, first to last:
+
+	the command line flags
+	the section of the ROM in "roms"
+	"settings"
+	the Octo cartridge, the ROM database or the detected platform
+	the default values of the flags
+
+so a setting saved by one run is used the same way by the next ones.
+*/
+
+type userConfig struct {
+	Settings map[string]any           `json:"settings,omitempty"`
+	Keys     *keyConfig               `json:"keys,omitempty"`
+	ROMs     map[string]romUserConfig `json:"roms,omitempty"`
+
+	path     string
+	readOnly bool // -save=false or headless, the file is never written
+}
+
+type romUserConfig struct {
+	Name     string              `json:"name,omitempty"` // Only to help reading the file
+	Platform string              `json:"platform,omitempty"`
+	IPF      int                 `json:"ipf,omitempty"`
+	Palette  string              `json:"palette,omitempty"`
+	Keys     map[string][]string `json:"keys,omitempty"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "project_c8", "config.json")
+}
+
+// loadUserConfig reads the config file, a missing file is an empty config.
+func loadUserConfig(path string) (*userConfig, error) {
+	cfg := &userConfig{path: path}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// applySettings sets the flags found in the config, except the ones given
+// on the command line.
+func (cfg *userConfig) applySettings(explicit map[string]bool) error {
+	for name, value := range cfg.Settings {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", cfg.path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, settingString(value)); err != nil {
+			return fmt.Errorf("%s: setting %s: %v", cfg.path, name, err)
+		}
+	}
+	return nil
+}
+
+// chosen returns the flags chosen by the user, given on the command line or
+// found in "settings", they win over the settings of the ROM.
+func (cfg *userConfig) chosen(explicit map[string]bool) map[string]bool {
+	chosen := map[string]bool{}
+	for name := range cfg.Settings {
+		chosen[name] = true
+	}
+	for name := range explicit {
+		chosen[name] = true
+	}
+	return chosen
+}
+
+// mergeKeys adds the key bindings of the config to the ones of keys.json,
+// which win when both have them.
+func (cfg *userConfig) mergeKeys(keys keyConfig) keyConfig {
+	var merged keyConfig
+	if cfg.Keys != nil {
+		merged = *cfg.Keys
+	}
+	saved := merged
+	if len(keys.Default) > 0 {
+		merged.Default = keys.Default
+	}
+	if keys.DeadZone != 0 {
+		merged.DeadZone = keys.DeadZone
+	}
+	merged.ROMs = map[string]romKeyConfig{}
+	for hash, rom := range saved.ROMs {
+		merged.ROMs[strings.ToLower(hash)] = rom
+	}
+	for hash, rom := range cfg.ROMs {
+		if len(rom.Keys) > 0 {
+			merged.ROMs[strings.ToLower(hash)] = romKeyConfig{Name: rom.Name, Keys: rom.Keys}
+		}
+	}
+	for hash, rom := range keys.ROMs {
+		merged.ROMs[strings.ToLower(hash)] = rom
+	}
+	merged.Hotkeys = map[string][]string{}
+	for _, hotkeys := range []map[string][]string{saved.Hotkeys, keys.Hotkeys} {
+		for action, names := range hotkeys {
+			merged.Hotkeys[action] = names
+		}
+	}
+	return merged
+}
+
+func (cfg *userConfig) rom(hash string) (romUserConfig, bool) {
+	for h, rom := range cfg.ROMs {
+		if strings.EqualFold(h, hash) {
+			return rom, true
+		}
+	}
+	return romUserConfig{}, false
+}
+
+// settingString formats a value of the config for flag.Set. JSON numbers are
+// float64, fmt would write the large ones with an exponent.
+func settingString(value any) string {
+	if v, ok := value.(float64); ok {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// savedSettings are the flags kept in the config when they are given on the
+// command line, the next runs use them without the flag (-save=false
+// changes them for one run only).
+var savedSettings = []string{"scale", "palette", "volume", "romdir"}
+
+// rememberFlags saves the savedSettings given on the command line.
+func (cfg *userConfig) rememberFlags(explicit map[string]bool) error {
+	settings := map[string]any{}
+	for _, name := range savedSettings {
+		if explicit[name] {
+			settings[name] = flag.Lookup(name).Value.(flag.Getter).Get()
+		}
+	}
+	return cfg.remember(settings)
+}
+
+// remember saves settings chosen by the user, the file is only written when
+// one of them changes.
+func (cfg *userConfig) remember(settings map[string]any) error {
+	changed := false
+	for name, value := range settings {
+		if old, ok := cfg.Settings[name]; !ok || settingString(old) != settingString(value) {
+			if cfg.Settings == nil {
+				cfg.Settings = map[string]any{}
+			}
+			cfg.Settings[name] = value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return cfg.write()
+}
+
+// rememberROM saves the settings of a ROM chosen by the user in its section,
+// the empty ones are left as they were.
+func (cfg *userConfig) rememberROM(rom *romSetup, settings romUserConfig) error {
+	hash := rom.hash
+	for h := range cfg.ROMs {
+		if strings.EqualFold(h, hash) {
+			hash = h
+		}
+	}
+	old := cfg.ROMs[hash]
+	saved := old
+	if settings.Platform != "" {
+		saved.Platform = settings.Platform
+	}
+	if settings.IPF > 0 {
+		saved.IPF = settings.IPF
+	}
+	if settings.Palette != "" {
+		saved.Palette = settings.Palette
+	}
+	if saved.Platform == old.Platform && saved.IPF == old.IPF && saved.Palette == old.Palette {
+		return nil
+	}
+	if saved.Name == "" {
+		saved.Name = rom.name
+	}
+	if cfg.ROMs == nil {
+		cfg.ROMs = map[string]romUserConfig{}
+	}
+	cfg.ROMs[hash] = saved
+	return cfg.write()
+}
+
+// write saves the config in its file.
+func (cfg *userConfig) write() error {
+	if cfg.path == "" || cfg.readOnly {
+		return nil
+	}
+	data, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(cfg.path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRememberROM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := &userConfig{path: path, ROMs: map[string]romUserConfig{"ABCD": {Palette: "000000,FFFFFF"}}}
+	rom := &romSetup{hash: "abcd", name: "PONG"}
+	if err := cfg.rememberROM(rom, romUserConfig{Platform: "schip", IPF: 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadUserConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.ROMs) != 1 {
+		t.Fatalf("sections %v, want the one of the ROM updated", loaded.ROMs)
+	}
+	saved, ok := loaded.rom("abcd")
+	if !ok {
+		t.Fatal("section of the ROM not saved")
+	}
+	want := romUserConfig{Name: "PONG", Platform: "schip", IPF: 30, Palette: "000000,FFFFFF"}
+	if saved.Name != want.Name || saved.Platform != want.Platform || saved.IPF != want.IPF || saved.Palette != want.Palette {
+		t.Errorf("saved %+v, want %+v", saved, want)
+	}
+}
+
+func TestReadOnlyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := &userConfig{path: path, readOnly: true}
+	if err := cfg.remember(map[string]any{"romdir": "/games"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.rememberROM(&romSetup{hash: "abcd"}, romUserConfig{IPF: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the config was written with -save=false: %v", err)
+	}
+}
+
+func TestSettingString(t *testing.T) {
+	tests := map[any]string{
+		float64(12345678): "12345678",
+		0.25:              "0.25",
+		"000000,FFFFFF":   "000000,FFFFFF",
+		true:              "true",
+	}
+	for value, want := range tests {
+		if got := settingString(value); got != want {
+			t.Errorf("settingString(%v) = %q, want %q", value, got, want)
+		}
+	}
+}
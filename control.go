@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+Hotkeys.
+
+The keys that control the emulator can be changed in the "hotkeys" section
+of the key bindings file, with the same key names as the keypad bindings:
+
+	"hotkeys": {"pause": ["P", "Pause"], "fastforward": ["Tab"]}
+
+An action listed there loses its default keys, and a key it takes is
+removed from the action that had it by default. Two actions of the file
+can't share a key. Hotkeys win over the keypad bindings when both use the
+same key.
+*/
+
+type hotkey int
+
+const (
+	hotkeyPause        hotkey = iota // Pause and resume
+	hotkeyReset                      // Start the ROM again
+	hotkeyFrameAdvance               // Run one frame and pause
+	hotkeyFastForward                // Run faster while held
+	hotkeySlowMotion                 // Toggle slow motion
+	hotkeyMenu                       // Open the ROM menu
+	hotkeyKeypad                     // Show or hide the keypad
+	hotkeyStatus                     // Show or hide the status
+	hotkeyRecord                     // Start or stop a GIF recording
+	hotkeyFullscreen                 // Toggle fullscreen, with Shift select the next monitor
+	hotkeyScreenshot                 // Save a screenshot
+	hotkeyCartridge                  // Save the ROM as an Octo cartridge
+)
+
+var hotkeyNames = map[string]hotkey{
+	"pause":       hotkeyPause,
+	"reset":       hotkeyReset,
+	"advance":     hotkeyFrameAdvance,
+	"fastforward": hotkeyFastForward,
+	"slowmotion":  hotkeySlowMotion,
+	"menu":        hotkeyMenu,
+	"keypad":      hotkeyKeypad,
+	"status":      hotkeyStatus,
+	"record":      hotkeyRecord,
+	"fullscreen":  hotkeyFullscreen,
+	"screenshot":  hotkeyScreenshot,
+	"cartridge":   hotkeyCartridge,
+}
+
+var defaultHotkeys = map[string][]string{
+	"pause":       {"P", "Pause"},
+	"reset":       {"F5"},
+	"advance":     {"N"},
+	"fastforward": {"Tab"},
+	"slowmotion":  {"M"},
+	"menu":        {"F1"},
+	"keypad":      {"F2"},
+	"status":      {"F3"},
+	"record":      {"F9"},
+	"fullscreen":  {"F11"},
+	"screenshot":  {"F12"},
+	"cartridge":   {"F7"},
+}
+
+// buildHotkeys returns the hotkeys with the changes of the config.
+func buildHotkeys(cfg map[string][]string) (map[glfw.Key]hotkey, error) {
+	configured := map[string][]string{}
+	for action, keys := range cfg {
+		if _, ok := hotkeyNames[strings.ToLower(action)]; !ok {
+			return nil, fmt.Errorf("unknown hotkey action %q", action)
+		}
+		configured[strings.ToLower(action)] = keys
+	}
+	// Sorted, the error for a shared key is the same on every run
+	actions := make([]string, 0, len(configured))
+	for action := range configured {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	hotkeys := map[glfw.Key]hotkey{}
+	owners := map[glfw.Key]string{}
+	for _, action := range actions {
+		for _, name := range configured[action] {
+			key, ok := keyNames[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown key %q for hotkey %s", name, action)
+			}
+			if owner, ok := owners[key]; ok && owner != action {
+				return nil, fmt.Errorf("key %q is bound to both hotkeys %s and %s", name, owner, action)
+			}
+			hotkeys[key], owners[key] = hotkeyNames[action], action
+		}
+	}
+	// The defaults of the other actions, without the keys taken above
+	for action, keys := range defaultHotkeys {
+		if _, ok := configured[action]; ok {
+			continue
+		}
+		for _, name := range keys {
+			key := keyNames[strings.ToLower(name)]
+			if _, taken := owners[key]; !taken {
+				hotkeys[key] = hotkeyNames[action]
+			}
+		}
+	}
+	return hotkeys, nil
+}
+
+// controlInput is implemented by the backends with emulation hotkeys.
+type controlInput interface {
+	// Controls returns the hotkeys pressed since the last call and if fast
+	// forward is held down.
+	Controls() (pressed []hotkey, fastForward bool)
+}
+
+// emulationSpeed is the state changed by the hotkeys.
+type emulationSpeed struct {
+	paused      bool
+	advance     bool // Run one frame while paused
+	fastForward bool
+	slowMotion  bool
+
+	fastForwardRate int     // Frames emulated per frame shown
+	slowMotionRate  float64 // Fraction of the normal speed
+}
+
+// state describes the speed for the status, empty at normal speed.
+func (s emulationSpeed) state() string {
+	switch {
+	case s.paused:
+		return "Paused"
+	case s.fastForward:
+		return fmt.Sprintf("Fast forward x%d", s.fastForwardRate)
+	case s.slowMotion:
+		return fmt.Sprintf("Slow motion x%g", s.slowMotionRate)
+	}
+	return ""
+}
+
+// frames returns the number of frames to emulate before the next one is
+// shown.
+func (s *emulationSpeed) frames() int {
+	switch {
+	case s.paused && s.advance:
+		s.advance = false
+		return 1
+	case s.paused:
+		return 0
+	case s.fastForward:
+		return s.fastForwardRate
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+func TestBuildHotkeysDefaults(t *testing.T) {
+	hotkeys, err := buildHotkeys(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[glfw.Key]hotkey{glfw.KeyP: hotkeyPause, glfw.KeyF5: hotkeyReset, glfw.KeyF1: hotkeyMenu} {
+		if got, ok := hotkeys[key]; !ok || got != want {
+			t.Errorf("key %d is hotkey %d (%v), want %d", key, got, ok, want)
+		}
+	}
+}
+
+func TestBuildHotkeysTakesDefaultKeys(t *testing.T) {
+	// F5 is the default of reset, pause takes it
+	for range 20 {
+		hotkeys, err := buildHotkeys(map[string][]string{"Pause": {"F5"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hotkeys[glfw.KeyF5]; got != hotkeyPause {
+			t.Fatalf("F5 is hotkey %d, want pause", got)
+		}
+		if _, ok := hotkeys[glfw.KeyP]; ok {
+			t.Fatal("P still pauses, pause lost its default keys")
+		}
+	}
+}
+
+func TestBuildHotkeysErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string][]string
+		want string
+	}{
+		{"shared key", map[string][]string{"reset": {"R"}, "pause": {"R"}}, `key "R" is bound to both hotkeys pause and reset`},
+		{"unknown action", map[string][]string{"jump": {"J"}}, `unknown hotkey action "jump"`},
+		{"unknown key", map[string][]string{"pause": {"Nope"}}, `unknown key "Nope" for hotkey pause`},
+	}
+	for _, tt := range tests {
+		if _, err := buildHotkeys(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -18,6 +18,11 @@ type cpu struct {
 	stack       [16]uint16    // Stack for subroutine calls
 	key         [16]byte      // Key state (0-15)
 	drawFlag    bool
+	rng         *rand.Rand // Used by CXNN, seeded so input replays are repeatable
+	quirks      quirks
+	wait        keyWait  // FX0A waiting for a key
+	vblankWait  bool     // Idle until the next frame after DXYN (vblank quirk)
+	polled      [16]bool // Keys checked by EX9E, EXA1 or FX0A in this frame
 }
 
 // CHIP-8 fontset: each character is 4x5 pixels
@@ -67,6 +72,8 @@ func (c *cpu) initEmulator() {
 		c.key[i] = 0
 		c.V[i] = 0
 	}
+	c.wait = keyWait{}
+	c.vblankWait = false
 
 	// Clear memory
 	for i := range 4096 {
@@ -91,6 +98,14 @@ Every cycle, the method emulateCycle is called which emulates one cycle of the C
 During this cycle, the CPU fetches the opcode, decodes it, and executes it.
 */
 func (c *cpu) emulateCycle() {
+	if c.vblankWait {
+		return
+	}
+	if c.wait.active {
+		c.waitForKey()
+		return
+	}
+
 	// Fetch opcode
 	c.opcode = uint16(c.memory[c.PC])<<8 | uint16(c.memory[c.PC+1])
 	/*
@@ -136,7 +151,7 @@ func (c *cpu) emulateCycle() {
 			// top of the stack
 			c.PC += 2 // Increment program counter by 2
 		default:
-			fmt.Printf("Unknown opcode: 0x%X\n", c.opcode)
+			notify("Unknown opcode 0x%04X at 0x%03X", c.opcode, c.PC)
 		}
 
 	case 0xA000: // ANNN
@@ -188,12 +203,15 @@ func (c *cpu) emulateCycle() {
 			c.PC += 2
 		case 0x0001: // 8XY1: Sets Vx to Vx OR Vy
 			c.V[(c.opcode&0x0F00)>>8] |= c.V[byte(c.opcode&0x00F0>>4)]
+			c.logicQuirk()
 			c.PC += 2
 		case 0x0002: // 8XY2: Sets Vx to Vx AND Vy
 			c.V[(c.opcode&0x0F00)>>8] &= c.V[byte(c.opcode&0x00F0>>4)]
+			c.logicQuirk()
 			c.PC += 2
 		case 0x0003: // 8XY3: Sets Vx to Vx XOR Vy
 			c.V[(c.opcode&0x0F00)>>8] ^= c.V[byte(c.opcode&0x00F0>>4)]
+			c.logicQuirk()
 			c.PC += 2
 		case 0x0004: // 8XY4: Adds Vy to Vx. VF is set to 1 when there's overflow. 0 when not
 			// Adds VY to VX. Vf is set to 1 when there's an overflow, and to 0 when there is not.
@@ -213,8 +231,9 @@ func (c *cpu) emulateCycle() {
 			c.V[(c.opcode&0x0F00)>>8] -= c.V[(c.opcode&0x00F0)>>4]
 			c.PC += 2
 		case 0x0006: // 8XY6: Set Vx to Vy and shift Vx one bit to the right, set Vf to the bit shifted out, even if X=F!
-			c.V[0xF] = c.V[(c.opcode&0x0F00)>>8] & 0x1
-			c.V[(c.opcode&0x0F00)>>8] >>= 1
+			value := c.shiftSource()
+			c.V[(c.opcode&0x0F00)>>8] = value >> 1
+			c.V[0xF] = value & 0x1
 			c.PC += 2
 		case 0x0007: // 8XY7: Set Vx to the result of subtracting Vx from Vy, Vf is set to 0 if an underflow happened, to 1 if not, even if X=F!
 			if c.V[(c.opcode&0x0F00)>>8] > c.V[(c.opcode&0x00F0)>>4] {
@@ -225,11 +244,12 @@ func (c *cpu) emulateCycle() {
 			c.V[(c.opcode&0x0F00)>>8] = c.V[(c.opcode&0x00F0)>>4] - c.V[(c.opcode&0x0F00)>>8]
 			c.PC += 2
 		case 0x000E: // 8XYE: Set Vx to Vy and shift Vx one bit to the left, set Vf to the bit shifted out, even if X=F!
-			c.V[0xF] = c.V[(c.opcode&0x0F00)>>8] >> 7
-			c.V[(c.opcode&0x0F00)>>8] <<= 1
+			value := c.shiftSource()
+			c.V[(c.opcode&0x0F00)>>8] = value << 1
+			c.V[0xF] = value >> 7
 			c.PC += 2
 		default:
-			fmt.Printf("Unknown opcode: 0x%X\n", c.opcode)
+			notify("Unknown opcode 0x%04X at 0x%03X", c.opcode, c.PC)
 		}
 	case 0x9000: // 9XY0: Skips the next instruction if VX does not equal VY
 		if c.V[(c.opcode&0x0F00)>>8] != c.V[(c.opcode&0x00F0)>>4] {
@@ -238,9 +258,14 @@ func (c *cpu) emulateCycle() {
 			c.PC += 2
 		}
 	case 0xB000: // BNNN: Jumps to the addres plus V0. PC = V0 + NNN
-		c.PC = (c.opcode & 0x0FFF) + uint16(c.V[0])
+		if c.quirks.Jump {
+			// BXNN: Jumps to XNN plus VX
+			c.PC = (c.opcode & 0x0FFF) + uint16(c.V[(c.opcode&0x0F00)>>8])
+		} else {
+			c.PC = (c.opcode & 0x0FFF) + uint16(c.V[0])
+		}
 	case 0xC000: // 0xCXNN: Sets Vx to the result of a bitwise AND operation on a random number and NN.
-		c.V[(c.opcode&0x0F00)>>8] = byte(rand.Intn(256)) & 0x00FF
+		c.V[(c.opcode&0x0F00)>>8] = byte(c.rng.Intn(256)) & 0x00FF
 		c.PC += 2
 	case 0xD000: // DXYN
 		/* This opcode is responsible for drawing to the display
@@ -256,23 +281,38 @@ func (c *cpu) emulateCycle() {
 
 		c.V[0xF] = 0 // Resets the register VF (Collision flag)
 		for yline := uint16(0); yline < height; yline++ {
-			pixel = uint16(c.memory[c.I+yline])
-			for xline := 0; xline < 8; xline++ {
+			py := y + yline
+			if py >= 32 {
+				if !c.quirks.Wrap {
+					break // Clipped at the bottom of the screen
+				}
+				py %= 32
+			}
+			pixel = uint16(c.memory[(c.I+yline)&0xFFF])
+			for xline := uint16(0); xline < 8; xline++ {
 				if pixel&(0x80>>xline) != 0 {
-					if (x + uint16(xline) + ((y + uint16(yline)) * 64)) >= 2048 {
-						continue // Prevent out of bounds access
+					px := x + xline
+					if px >= 64 {
+						if !c.quirks.Wrap {
+							continue // Clipped at the right of the screen
+						}
+						px %= 64
 					}
-					if c.gfx[(x+uint16(xline)+((y+uint16(yline))*64))] == 1 {
+					if c.gfx[px+py*64] == 1 {
 						// If pixel is set to 1, set VF to 1 (collision)
 						c.V[0xF] = 1
 					}
 					// Set the pixel value by using XOR
-					c.gfx[x+uint16(xline)+((y+uint16(yline))*64)] ^= 1
+					c.gfx[px+py*64] ^= 1
 				}
 			}
 		}
 		c.drawFlag = true
 		c.PC += 2
+		if c.quirks.VBlank {
+			// The VIP waited for the display interrupt before drawing
+			c.vblankWait = true
+		}
 	case 0xE000: // 0xE000 is a prefix for key input opcodes
 		/*
 			0xE09E: Skip next instruction if key with value of Vx is pressed
@@ -282,12 +322,14 @@ func (c *cpu) emulateCycle() {
 		*/
 		switch c.opcode & 0x00FF {
 		case 0x9E: // Skip next instruction if key with value of Vx is pressed
+			c.polled[c.V[(c.opcode&0x0F00)>>8]&0xF] = true
 			if c.key[c.V[(c.opcode&0x0F00)>>8]] != 0 {
 				c.PC += 4 // Skip next instruction
 			} else {
 				c.PC += 2 // Just increment PC by 2
 			}
 		case 0xA1: // Skip next instruction if key with value of Vx is not pressed
+			c.polled[c.V[(c.opcode&0x0F00)>>8]&0xF] = true
 			if c.key[c.V[(c.opcode&0x0F00)>>8]] == 0 {
 				c.PC += 4 // Skip next instruction
 			} else {
@@ -300,19 +342,9 @@ func (c *cpu) emulateCycle() {
 			c.V[(c.opcode&0x0F00)>>8] = c.delay_timer
 			c.PC += 2
 		case 0x000A: // 0xFX0A: A key press is awaited, and then stored in Vx
-			// get_key() functionality
-			var keypress bool = false
-			for i := 0; i < 16; i++ {
-				if c.key[i] != 0 {
-					c.V[(c.opcode&0x0F00)>>8] = byte(i)
-					keypress = true
-				}
-			}
-			// If not key is recieved, skip this cycle
-			if !keypress {
-				return
-			}
-			c.PC += 2
+			// The cpu stops here until waitForKey sees a new key, the
+			// timers keep running meanwhile
+			c.wait = keyWait{active: true, register: byte((c.opcode & 0x0F00) >> 8), key: -1, held: c.key}
 		case 0x0015: // 0xFX15: Sets the delay timer to Vx
 			c.delay_timer = c.V[(c.opcode&0x0F00)>>8]
 			c.PC += 2
@@ -342,21 +374,19 @@ func (c *cpu) emulateCycle() {
 			for i := uint16(0); i <= ((c.opcode & 0x0F00) >> 8); i++ {
 				c.memory[c.I+i] = c.V[i]
 			}
-			// On the original interpreter, when the operation is done I = I + X +1
-			c.I += ((c.opcode & 0x0F00) >> 8) + 1
+			c.memoryQuirk()
 			c.PC += 2
 		case 0x0065: // 0xFX65: Fills from V0 to VX (including VX) with values from memory, starting at address I.
 			// The offset from I is increased by 1 for each value read, but I itself is left unmodified.
 			for i := 0; i <= int((c.opcode&0x0F00)>>8); i++ {
 				c.V[uint16(i)] = c.memory[c.I+uint16(i)]
 			}
-			// On the original interpreter, when the operation is done I = I + X +1
-			c.I += ((c.opcode & 0x0F00) >> 8) + 1
+			c.memoryQuirk()
 			c.PC += 2
 		}
 
 	default:
-		println("Unknown opcode:", c.opcode)
+		notify("Unknown opcode 0x%04X at 0x%03X", c.opcode, c.PC)
 		// Update timers
 		c.handleDelayTimer()
 		c.handleSoundTimer()
@@ -364,6 +394,64 @@ func (c *cpu) emulateCycle() {
 	}
 }
 
+// logicQuirk resets VF after 8XY1, 8XY2 and 8XY3, like the VIP.
+func (c *cpu) logicQuirk() {
+	if c.quirks.Logic {
+		c.V[0xF] = 0
+	}
+}
+
+// shiftSource returns the register shifted by 8XY6 and 8XYE, VY on the VIP
+// and VX with the shift quirk.
+func (c *cpu) shiftSource() byte {
+	if c.quirks.Shift {
+		return c.V[(c.opcode&0x0F00)>>8]
+	}
+	return c.V[(c.opcode&0x00F0)>>4]
+}
+
+// memoryQuirk moves I after FX55 and FX65. On the original interpreter,
+// when the operation is done I = I + X + 1.
+func (c *cpu) memoryQuirk() {
+	x := (c.opcode & 0x0F00) >> 8
+	switch {
+	case c.quirks.MemoryLeaveIUnchanged:
+	case c.quirks.MemoryIncrementByX:
+		c.I += x
+	default:
+		c.I += x + 1
+	}
+}
+
+// waitForKey checks the keys while FX0A waits. A key counts when it is
+// pressed after FX0A started, and with keyWaitRelease only once it is
+// released again.
+func (c *cpu) waitForKey() {
+	w := &c.wait
+	for i := range c.polled {
+		c.polled[i] = true
+	}
+	if w.key < 0 {
+		for i := range 16 {
+			if c.key[i] != 0 && w.held[i] == 0 {
+				w.key = i
+				break
+			}
+			// A key released since FX0A started can be pressed again
+			w.held[i] &= c.key[i]
+		}
+		if w.key < 0 || c.quirks.KeyWaitRelease {
+			return
+		}
+	} else if c.key[w.key] != 0 {
+		return
+	}
+
+	c.V[w.register] = byte(w.key)
+	c.wait = keyWait{}
+	c.PC += 2
+}
+
 func (c *cpu) handleTimers() {
 	c.handleDelayTimer()
 	c.handleSoundTimer()
@@ -375,11 +463,9 @@ func (c *cpu) handleDelayTimer() {
 	}
 }
 
+// The buzzer itself is played by the Audio backend while sound_timer > 0
 func (c *cpu) handleSoundTimer() {
 	if c.sound_timer > 0 {
-		if c.sound_timer == 1 {
-			fmt.Println("BEEP!")
-		}
 		c.sound_timer--
 	}
 }
@@ -407,6 +493,13 @@ func (c *cpu) codeDebugger() {
 	}
 	fmt.Println()
 	fmt.Printf("Delay Timer: %d, Sound Timer: %d\n", c.delay_timer, c.sound_timer)
+	if c.wait.active {
+		if c.wait.key < 0 {
+			fmt.Printf("Waiting for a key (FX0A), result in V[%d]\n", c.wait.register)
+		} else {
+			fmt.Printf("Waiting for key %X to be released (FX0A), result in V[%d]\n", c.wait.key, c.wait.register)
+		}
+	}
 
 	fmt.Println("Stack:")
 	for i := 0; i < int(c.SP); i++ {
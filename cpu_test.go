@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+// newWaitingCPU returns a cpu that ran FX0A with the given keys held, so it
+// waits for a key to put in V3.
+func newWaitingCPU(t *testing.T, release bool, held [16]byte) *cpu {
+	t.Helper()
+	c := &cpu{}
+	c.initEmulator()
+	c.loadGame([]byte{0xF3, 0x0A, 0x12, 0x02})
+	c.quirks.KeyWaitRelease = release
+	c.key = held
+	c.emulateCycle()
+	if !c.wait.active {
+		t.Fatal("FX0A didn't start waiting for a key")
+	}
+	return c
+}
+
+// step runs one cycle with the given keys held.
+func step(c *cpu, keys [16]byte) {
+	c.key = keys
+	c.emulateCycle()
+}
+
+func TestWaitForKeyOnPress(t *testing.T) {
+	c := newWaitingCPU(t, false, [16]byte{})
+	step(c, [16]byte{})
+	if !c.wait.active || c.PC != 0x200 {
+		t.Fatalf("stopped waiting without a key, PC %03X", c.PC)
+	}
+	step(c, pressed(0x7))
+	if c.wait.active {
+		t.Fatal("still waiting after a key press")
+	}
+	if c.V[3] != 0x7 || c.PC != 0x202 {
+		t.Errorf("V3 = %X, PC = %03X, want 7 and 202", c.V[3], c.PC)
+	}
+}
+
+func TestWaitForKeyOnRelease(t *testing.T) {
+	c := newWaitingCPU(t, true, [16]byte{})
+	step(c, pressed(0x7))
+	step(c, pressed(0x7, 0x2))
+	if !c.wait.active {
+		t.Fatal("stopped waiting while the key is held")
+	}
+	step(c, pressed(0x2))
+	if c.wait.active {
+		t.Fatal("still waiting after the key was released")
+	}
+	if c.V[3] != 0x7 || c.PC != 0x202 {
+		t.Errorf("V3 = %X, PC = %03X, want 7 and 202", c.V[3], c.PC)
+	}
+}
+
+func TestWaitForKeyNeedsNewPress(t *testing.T) {
+	c := newWaitingCPU(t, false, pressed(0x1))
+	step(c, pressed(0x1))
+	if !c.wait.active {
+		t.Fatal("a key held before FX0A ended the wait")
+	}
+	// Released and pressed again, it counts
+	step(c, [16]byte{})
+	step(c, pressed(0x1))
+	if c.wait.active || c.V[3] != 0x1 {
+		t.Errorf("wait %v, V3 = %X, want the key pressed again", c.wait.active, c.V[3])
+	}
+}
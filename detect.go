@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+/*
+Platform detection.
+
+ROMs missing from the database get a platform from the instructions they
+use. Only the code reachable from 0x200 is looked at, following jumps, calls
+and skips, sprites and other data would look like random instructions.
+
+	SUPER-CHIP  00FF 00FE 00FB 00FC 00FD 00CN DXY0 FX30 FX75 FX85
+	XO-CHIP     F000 NNNN, 5XY2 5XY3 FN01 F002 FX3A 00DN
+	VIP hybrid  0NNN, calls to machine code of the 1802
+	VIP         FX55/FX65 followed by another memory access without
+	            setting I again, the program counts on I being moved, and
+	            8XY6/8XYE with X different from Y
+
+Every hint adds to the score of its platform, the confidence is the share
+of the winner in the total plus one point of doubt.
+*/
+
+type detection struct {
+	platform   string
+	confidence float64 // 0-1
+	reasons    []string
+}
+
+type platformHint struct {
+	platform string
+	weight   float64
+	reason   string
+}
+
+// detectPlatform guesses the platform of a ROM loaded at 0x200.
+func detectPlatform(rom []byte) detection {
+	var hints []platformHint
+	seen := map[string]bool{} // One hint per instruction kind
+	hint := func(platform string, weight float64, kind string, addr int, op uint16) {
+		if seen[kind] {
+			return
+		}
+		seen[kind] = true
+		hints = append(hints, platformHint{platform, weight, fmt.Sprintf("%s (%04X at 0x%03X)", kind, op, addr)})
+	}
+
+	code := reachableCode(rom)
+	for i, addr := range code {
+		op := opcodeAt(rom, addr)
+		x, y := (op&0x0F00)>>8, (op&0x00F0)>>4
+		switch {
+		case op == 0x00FF || op == 0x00FE:
+			hint("superchip", 3, "SUPER-CHIP screen mode", addr, op)
+		case op == 0x00FB || op == 0x00FC || op&0xFFF0 == 0x00C0:
+			hint("superchip", 3, "SUPER-CHIP scroll", addr, op)
+		case op == 0x00FD:
+			hint("superchip", 3, "SUPER-CHIP exit", addr, op)
+		case op&0xF00F == 0xD000:
+			hint("superchip", 2, "16x16 sprite", addr, op)
+		case op&0xF0FF == 0xF030:
+			hint("superchip", 3, "SUPER-CHIP big font", addr, op)
+		case op&0xF0FF == 0xF075 || op&0xF0FF == 0xF085:
+			hint("superchip", 3, "SUPER-CHIP flag registers", addr, op)
+		case op == 0xF000:
+			hint("xochip", 5, "XO-CHIP long I", addr, op)
+		case op&0xF00E == 0x5002:
+			hint("xochip", 5, "XO-CHIP register range", addr, op)
+		case op&0xF0FF == 0xF001:
+			hint("xochip", 5, "XO-CHIP plane", addr, op)
+		case op == 0xF002 || op&0xF0FF == 0xF03A:
+			hint("xochip", 5, "XO-CHIP audio", addr, op)
+		case op&0xFFF0 == 0x00D0:
+			hint("xochip", 5, "XO-CHIP scroll up", addr, op)
+		case op&0xF000 == 0x0000 && op != 0x00E0 && op != 0x00EE:
+			hint("hybridVIP", 3, "machine code call", addr, op)
+		case op&0xF00F == 0x8006 && x != y, op&0xF00F == 0x800E && x != y:
+			hint("originalChip8", 1, "shift from VY", addr, op)
+		case op&0xF0FF == 0xF055 || op&0xF0FF == 0xF065:
+			if usesMovedI(rom, code[i+1:]) {
+				hint("originalChip8", 1, "I reused after FX55/FX65", addr, op)
+			}
+		}
+	}
+
+	scores := map[string]float64{"originalChip8": 0.5}
+	total := 0.5
+	for _, h := range hints {
+		scores[h.platform] += h.weight
+		total += h.weight
+	}
+	// XO-CHIP includes the SUPER-CHIP instructions
+	if scores["xochip"] > 0 {
+		scores["xochip"] += scores["superchip"]
+		scores["superchip"] = 0
+	}
+
+	d := detection{platform: "originalChip8"}
+	for _, p := range []string{"originalChip8", "hybridVIP", "superchip", "xochip"} {
+		if scores[p] > scores[d.platform] {
+			d.platform = p
+		}
+	}
+	// One more point of doubt, a single weak hint isn't certainty
+	d.confidence = scores[d.platform] / (total + 1)
+	if len(hints) == 0 {
+		d.confidence = 0.4 // Plain CHIP-8, but nothing says which interpreter
+		d.reasons = []string{"only CHIP-8 instructions"}
+	}
+	for _, h := range hints {
+		d.reasons = append(d.reasons, h.platform+": "+h.reason)
+	}
+	return d
+}
+
+func opcodeAt(rom []byte, addr int) uint16 {
+	i := addr - 0x200
+	if i < 0 || i+1 >= len(rom) {
+		return 0
+	}
+	return uint16(rom[i])<<8 | uint16(rom[i+1])
+}
+
+// reachableCode returns the addresses of the instructions that can be run,
+// in the order they are found following the program from 0x200.
+func reachableCode(rom []byte) []int {
+	end := 0x200 + len(rom)
+	visited := map[int]bool{}
+	var code []int
+	pending := []int{0x200}
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for addr >= 0x200 && addr+1 < end && !visited[addr] {
+			visited[addr] = true
+			op := opcodeAt(rom, addr)
+			if op == 0x0000 {
+				break // Running into empty memory, not code
+			}
+			code = append(code, addr)
+
+			size := 2
+			if op == 0xF000 {
+				size = 4 // XO-CHIP F000 NNNN
+			}
+			next := addr + size
+			switch {
+			case op == 0x00EE || op == 0x00FD:
+				next = -1
+			case op&0xF000 == 0x1000:
+				next = int(op & 0x0FFF)
+			case op&0xF000 == 0x2000:
+				pending = append(pending, int(op&0x0FFF))
+			case op&0xF000 == 0xB000:
+				next = -1 // Computed jump, the target is unknown
+			case op&0xF000 == 0x3000, op&0xF000 == 0x4000, op&0xF00F == 0x5000, op&0xF00F == 0x9000,
+				op&0xF0FF == 0xE09E, op&0xF0FF == 0xE0A1:
+				// Skip over the next instruction, which takes 4 bytes if it is F000 NNNN
+				skip := next + 2
+				if opcodeAt(rom, next) == 0xF000 {
+					skip = next + 4
+				}
+				pending = append(pending, skip)
+			}
+			addr = next
+		}
+	}
+	return code
+}
+
+// usesMovedI reports whether the code after FX55/FX65 reads or writes memory
+// again before setting I, counting on I having moved past the registers.
+func usesMovedI(rom []byte, after []int) bool {
+	for _, addr := range after[:min(len(after), 8)] {
+		op := opcodeAt(rom, addr)
+		switch {
+		case op&0xF000 == 0xA000, op&0xF0FF == 0xF029, op&0xF0FF == 0xF01E, op&0xF000 == 0x1000,
+			op&0xF000 == 0x2000, op == 0x00EE:
+			return false
+		case op&0xF0FF == 0xF055, op&0xF0FF == 0xF065, op&0xF0FF == 0xF033, op&0xF000 == 0xD000:
+			return true
+		}
+	}
+	return false
+}
+
+// runInfo is the info command: prints what is known about each ROM.
+func runInfo(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	romDBPath := fs.String("romdb", "", "directory with the files of the CHIP-8 database, replacing the embedded copy")
+	fs.Usage = func() {
+		fmt.Println("usage: c8 info [-romdb dir] ROM...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	db, err := loadROMDatabase(*romDBPath)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		// Read the same as when the ROM is loaded: the hash is the one of the
+		// ROM before the patch, the detection looks at the patched code
+		data, cart, err := readROM(path)
+		if err != nil {
+			fmt.Println(err)
+			status = 1
+			continue
+		}
+		sum := sha1.Sum(data)
+		hash := hex.EncodeToString(sum[:])
+		patch := findPatch(path)
+		if patch != "" {
+			if data, err = applyPatch(data, patch); err != nil {
+				fmt.Println(err)
+				status = 1
+				continue
+			}
+		}
+		fmt.Printf("%s\n  SHA-1:    %s\n  Size:     %d bytes\n", path, hash, len(data))
+		if patch != "" {
+			fmt.Printf("  Patch:    %s\n", patch)
+		}
+		if p, ok := db.lookup(hash); ok {
+			fmt.Printf("  Database: %s, platform %s, tickrate %d\n", p.title, p.platform.ID, p.tickrate)
+		} else if db.loaded() {
+			fmt.Println("  Database: not found")
+		} else {
+			fmt.Println("  Database: empty, see go generate or -romdb")
+		}
+		if cart != nil {
+			p := cart.Options.profile(db)
+			fmt.Printf("  Octo:     cartridge for %s, tickrate %d\n", p.platform.ID, p.tickrate)
+		}
+		d := detectPlatform(data)
+		fmt.Printf("  Detected: %s (%.0f%% confidence)\n", d.platform, d.confidence*100)
+		fmt.Printf("            %s\n", strings.Join(d.reasons, "\n            "))
+	}
+	return status
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+		want string
+	}{
+		{"plain CHIP-8", []byte{0x00, 0xE0, 0x60, 0x01, 0x12, 0x02}, "originalChip8"},
+		{"SUPER-CHIP high resolution", []byte{0x00, 0xFF, 0xD0, 0x10, 0x12, 0x02}, "superchip"},
+		{"XO-CHIP long I", []byte{0xF0, 0x00, 0x03, 0x00, 0x00, 0xFF, 0x12, 0x06}, "xochip"},
+		{"machine code call", []byte{0x01, 0x23, 0x12, 0x02}, "hybridVIP"},
+		// 00FF after the endless loop is data, not code
+		{"unreachable hint", []byte{0x12, 0x00, 0x00, 0xFF}, "originalChip8"},
+		// The instruction after a skip runs or not, both paths are followed
+		{"hint after a skip", []byte{0x30, 0x00, 0x12, 0x08, 0x00, 0xFF, 0x12, 0x04, 0x12, 0x08}, "superchip"},
+	}
+	for _, tt := range tests {
+		d := detectPlatform(tt.rom)
+		if d.platform != tt.want {
+			t.Errorf("%s: detected %s, want %s (%v)", tt.name, d.platform, tt.want, d.reasons)
+		}
+		if d.confidence <= 0 || d.confidence > 1 {
+			t.Errorf("%s: confidence %v out of range", tt.name, d.confidence)
+		}
+	}
+}
+
+func TestDetectPlatformWithoutHints(t *testing.T) {
+	d := detectPlatform([]byte{0x60, 0x05, 0x12, 0x02})
+	if d.confidence != 0.4 || len(d.reasons) != 1 || d.reasons[0] != "only CHIP-8 instructions" {
+		t.Errorf("got %v %v, want a 0.4 confidence with no hints", d.confidence, d.reasons)
+	}
+}
+
+func TestReachableCode(t *testing.T) {
+	// Call 0x206, which returns, then loop, the bytes at 0x208 are data
+	rom := []byte{0x22, 0x06, 0x12, 0x04, 0x12, 0x04, 0x00, 0xEE, 0xFF, 0xFF}
+	code := reachableCode(rom)
+	want := map[int]bool{0x200: true, 0x202: true, 0x204: true, 0x206: true}
+	if len(code) != len(want) {
+		t.Fatalf("reachable %X, want %v", code, want)
+	}
+	for _, addr := range code {
+		if !want[addr] {
+			t.Errorf("0x%03X found reachable", addr)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+Gamepad and joystick input.
+
+Controller buttons and axes are bound to CHIP-8 keys in the same key bindings
+file as the keyboard, so they can also be changed per ROM:
+
+	"5": ["W", "button:0", "axis:1-"]
+
+"button:N" is the button N of any connected controller and "axis:N-" or
+"axis:N+" is the axis N pushed to its negative or positive side past the
+dead zone, set with "deadzone" in the bindings file (0.5 by default).
+
+GLFW 3.2 has no standard gamepad layout, the numbers of the buttons and axes
+depend on the controller. Controllers can be plugged and unplugged while the
+emulator runs, all the connected ones are read every frame.
+*/
+
+const defaultDeadZone = 0.5
+
+// Bindings used without a "default" map: the first stick or the d-pad axes
+// as 2/4/6/8 and the first button as 5, the usual keys of CHIP-8 games.
+var defaultGamepadBindings = map[byte][]string{
+	0x2: {"axis:1-"},
+	0x8: {"axis:1+"},
+	0x4: {"axis:0-"},
+	0x6: {"axis:0+"},
+	0x5: {"button:0"},
+}
+
+type axisDirection struct {
+	axis     int
+	positive bool
+}
+
+// gamepadSource gives the state of the connected controllers, GLFW in the
+// emulator and a fake in tests.
+type gamepadSource interface {
+	connected() []int
+	axes(id int) []float32
+	buttons(id int) []byte // 1 for pressed
+}
+
+type glfwGamepads struct{}
+
+func (glfwGamepads) connected() []int {
+	var ids []int
+	for joy := glfw.Joystick1; joy <= glfw.JoystickLast; joy++ {
+		if glfw.JoystickPresent(joy) {
+			ids = append(ids, int(joy))
+		}
+	}
+	return ids
+}
+
+func (glfwGamepads) axes(id int) []float32 {
+	return glfw.GetJoystickAxes(glfw.Joystick(id))
+}
+
+func (glfwGamepads) buttons(id int) []byte {
+	return glfw.GetJoystickButtons(glfw.Joystick(id))
+}
+
+// watchGamepads reports controllers that are plugged or unplugged.
+func watchGamepads() {
+	glfw.SetJoystickCallback(func(joy, event int) {
+		if glfw.MonitorEvent(event) == glfw.Connected {
+			notify("Controller connected: %s", glfw.GetJoystickName(glfw.Joystick(joy)))
+		} else {
+			notify("Controller %d disconnected", joy+1)
+		}
+	})
+}
+
+// readGamepads sets in keys the CHIP-8 keys held on any controller. Keys
+// already pressed on the keyboard are left pressed.
+func readGamepads(source gamepadSource, m *keyMap, keys *[16]byte) {
+	if len(m.buttons) == 0 && len(m.axes) == 0 {
+		return
+	}
+	for _, id := range source.connected() {
+		for i, pressed := range source.buttons(id) {
+			if symbol, ok := m.buttons[i]; ok && pressed != 0 {
+				keys[symbol] = 1
+			}
+		}
+		for i, value := range source.axes(id) {
+			if value > m.deadZone {
+				if symbol, ok := m.axes[axisDirection{i, true}]; ok {
+					keys[symbol] = 1
+				}
+			} else if value < -m.deadZone {
+				if symbol, ok := m.axes[axisDirection{i, false}]; ok {
+					keys[symbol] = 1
+				}
+			}
+		}
+	}
+}
+
+// bindGamepad reads "button:N", "axis:N-" and "axis:N+".
+func (m *keyMap) bindGamepad(name string, symbol byte) error {
+	switch {
+	case strings.HasPrefix(name, "button:"):
+		button, err := strconv.Atoi(strings.TrimPrefix(name, "button:"))
+		if err != nil || button < 0 {
+			return fmt.Errorf("invalid button in %q", name)
+		}
+		m.buttons[button] = symbol
+	case strings.HasPrefix(name, "axis:"):
+		spec := strings.TrimPrefix(name, "axis:")
+		if len(spec) < 2 || (spec[len(spec)-1] != '-' && spec[len(spec)-1] != '+') {
+			return fmt.Errorf("axis %q needs a direction, like axis:0- or axis:0+", name)
+		}
+		axis, err := strconv.Atoi(spec[:len(spec)-1])
+		if err != nil || axis < 0 {
+			return fmt.Errorf("invalid axis in %q", name)
+		}
+		m.axes[axisDirection{axis, spec[len(spec)-1] == '+'}] = symbol
+	}
+	return nil
+}
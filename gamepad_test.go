@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+// fakeGamepads is a gamepadSource with a state set by hand.
+type fakeGamepads struct {
+	pads map[int]fakeGamepad
+}
+
+type fakeGamepad struct {
+	axes    []float32
+	buttons []byte
+}
+
+func (f *fakeGamepads) connected() []int {
+	var ids []int
+	for id := range f.pads {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (f *fakeGamepads) axes(id int) []float32 { return f.pads[id].axes }
+func (f *fakeGamepads) buttons(id int) []byte { return f.pads[id].buttons }
+
+func TestReadGamepadsDefaultBindings(t *testing.T) {
+	m, err := buildKeyMap(keyConfig{}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		pad  fakeGamepad
+		want [16]byte
+	}{
+		{"idle", fakeGamepad{axes: []float32{0, 0}, buttons: []byte{0}}, [16]byte{}},
+		{"inside the dead zone", fakeGamepad{axes: []float32{0.4, -0.4}}, [16]byte{}},
+		{"left and up", fakeGamepad{axes: []float32{-1, -1}}, pressed(0x4, 0x2)},
+		{"right and down", fakeGamepad{axes: []float32{0.9, 0.6}}, pressed(0x6, 0x8)},
+		{"first button", fakeGamepad{buttons: []byte{1, 1}}, pressed(0x5)},
+	}
+	for _, tt := range tests {
+		var keys [16]byte
+		readGamepads(&fakeGamepads{pads: map[int]fakeGamepad{0: tt.pad}}, m, &keys)
+		if keys != tt.want {
+			t.Errorf("%s: keys %v, want %v", tt.name, keys, tt.want)
+		}
+	}
+}
+
+func TestReadGamepadsKeepsKeyboardKeys(t *testing.T) {
+	m, err := buildKeyMap(keyConfig{}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pads := &fakeGamepads{pads: map[int]fakeGamepad{
+		0: {buttons: []byte{1}},
+		3: {axes: []float32{1}},
+	}}
+	keys := pressed(0xA)
+	readGamepads(pads, m, &keys)
+	if want := pressed(0xA, 0x5, 0x6); keys != want {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+	"time"
+)
+
+/*
+Animated GIF recording.
+
+Every presented frame is kept as a copy of gfx (2 KB each) and only scaled
+when the file is written. Identical consecutive frames are merged into one
+GIF frame with a longer delay.
+
+GIF delays are in 1/100 s, so 60 Hz frames don't fit exactly. The delay of
+each GIF frame is computed from the time of its first and last 60 Hz frame,
+which keeps the total length of the animation right.
+*/
+
+type recordedFrame struct {
+	gfx    [WIDTH * HEIGHT]byte
+	start  int // Number of the first 60 Hz frame shown by this GIF frame
+	frames int // Number of 60 Hz frames merged into it
+}
+
+type gifRecorder struct {
+	renderer  softRenderer
+	path      string // Output file, empty for a timestamped name
+	recording bool
+	frames    []recordedFrame
+	elapsed   int // 60 Hz frames captured since the recording started
+}
+
+func (r *gifRecorder) start() {
+	r.recording = true
+	r.frames = nil
+	r.elapsed = 0
+	notify("Recording started")
+}
+
+// toggle starts a recording, or stops the current one and saves it.
+func (r *gifRecorder) toggle() {
+	if !r.recording {
+		r.start()
+		return
+	}
+	r.stop()
+}
+
+func (r *gifRecorder) stop() {
+	if !r.recording {
+		return
+	}
+	r.recording = false
+
+	path := r.path
+	if path == "" {
+		path = "recording-" + time.Now().Format("20060102-150405") + ".gif"
+	}
+	if err := r.save(path); err != nil {
+		notify("Error saving recording: %v", err)
+		return
+	}
+	notify("Recording saved to %s (%d frames)", path, len(r.frames))
+}
+
+// capture must be called once for every presented frame.
+func (r *gifRecorder) capture(gfx *[WIDTH * HEIGHT]byte) {
+	if !r.recording {
+		return
+	}
+	if n := len(r.frames); n > 0 && r.frames[n-1].gfx == *gfx {
+		r.frames[n-1].frames++
+	} else {
+		r.frames = append(r.frames, recordedFrame{gfx: *gfx, start: r.elapsed, frames: 1})
+	}
+	r.elapsed++
+}
+
+// centiseconds returns the time of a 60 Hz frame in GIF units.
+func centiseconds(frame int) int {
+	return (frame*100 + 30) / 60
+}
+
+func (r *gifRecorder) save(path string) error {
+	if len(r.frames) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+
+	anim := &gif.GIF{}
+	for _, f := range r.frames {
+		delay := centiseconds(f.start+f.frames) - centiseconds(f.start)
+		anim.Image = append(anim.Image, r.renderer.render(&f.gfx))
+		anim.Delay = append(anim.Delay, delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+	}
+	anim.Config = image.Config{
+		ColorModel: r.renderer.palette.colors(),
+		Width:      WIDTH * r.renderer.scale,
+		Height:     HEIGHT * r.renderer.scale,
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+// Default key bindings, see keymap.go to change them
+var KEY_MAP = map[glfw.Key]byte{
+	glfw.Key1: 0x1,
+	glfw.Key2: 0x2,
+	glfw.Key3: 0x3,
+	glfw.Key4: 0xC,
+	glfw.KeyQ: 0x4,
+	glfw.KeyW: 0x5,
+	glfw.KeyE: 0x6,
+	glfw.KeyR: 0xD,
+	glfw.KeyA: 0x7,
+	glfw.KeyS: 0x8,
+	glfw.KeyD: 0x9,
+	glfw.KeyF: 0xE,
+	glfw.KeyZ: 0xA,
+	glfw.KeyX: 0x0,
+	glfw.KeyC: 0xB,
+	glfw.KeyV: 0xF,
+}
+
+type glfwOptions struct {
+	scale      int
+	scaling    scalingMode
+	fullscreen bool
+	monitor    int
+	postConfig string // Path of the post-processing chain
+	filter     *screenFilter
+	palette    palette
+	renderer   softRenderer // Used by the screenshot hotkey
+	recorder   *gifRecorder
+	osd        bool // Show the status on screen, F3 toggles it
+	keypad     bool // Show the keypad under the screen, F2 toggles it
+	keymap     *keyMap
+	menu       *romMenu
+	hotkeys    map[glfw.Key]hotkey
+}
+
+// glfwBackend is the Display and the Input of the OpenGL window.
+type glfwBackend struct {
+	ws       *windowState
+	program  uint32
+	filter   *screenFilter
+	post     *postPipeline
+	palette  palette
+	renderer softRenderer
+	recorder *gifRecorder
+	keymap   *keyMap
+	gamepads gamepadSource
+	keypad   *keypadView
+	menu     *romMenu
+	dropped  string // ROM dropped on the window, waiting to be loaded
+
+	hotkeys     map[glfw.Key]hotkey
+	controls    []hotkey // Hotkeys for the emulator, see Controls
+	fastForward bool
+
+	keys *keyQueue            // Filled by the key callback
+	last [WIDTH * HEIGHT]byte // Last presented frame, for screenshots
+
+	status     emulatorStatus
+	showStatus bool
+}
+
+func newGLFWBackend(opts glfwOptions) (*glfwBackend, error) {
+	width, height := WIDTH*opts.scale, HEIGHT*opts.scale
+	if opts.keypad {
+		height = height * 100 / keypadScreenShare
+	}
+	window, program, _, err := initWindowEmulator(width, height)
+	if err != nil {
+		glfw.Terminate()
+		return nil, err
+	}
+
+	b := &glfwBackend{
+		ws:       newWindowState(window, opts.scaling, opts.monitor),
+		program:  program,
+		filter:   opts.filter,
+		palette:  opts.palette,
+		renderer: opts.renderer,
+		recorder: opts.recorder,
+		keymap:   opts.keymap,
+		gamepads: glfwGamepads{},
+		keys:     newKeyQueue(),
+		keypad:   newKeypadView(),
+		menu:     opts.menu,
+		hotkeys:  opts.hotkeys,
+
+		showStatus: opts.osd,
+	}
+	b.ws.keypad = opts.keypad
+	if opts.fullscreen {
+		b.ws.toggleFullscreen()
+	}
+
+	postCfg, err := loadPostConfig(opts.postConfig)
+	if err != nil {
+		fmt.Printf("Error loading post-processing config: %v\n", err)
+	}
+	b.post = newPostPipeline(postCfg)
+
+	keyboardHandler(b)
+	mouseHandler(b)
+	dropHandler(b)
+	watchGamepads()
+	return b, nil
+}
+
+func (b *glfwBackend) Present(gfx *[WIDTH * HEIGHT]byte) {
+	b.last = *gfx
+
+	// The screen is redrawn every frame, even without drawFlag, so the
+	// filter can keep fading pixels that the game turned off.
+	b.filter.update(gfx)
+	drawSpriteOnWindow(b.ws, b.program, b.filter, b.post, b.palette)
+	if b.ws.keypad {
+		drawKeypad(b.ws, b.program, b.keypad)
+	}
+	if b.menu.open {
+		drawMenu(b.ws, b.program, b.menu)
+	}
+	drawOSD(b.ws, b.program, b.status, b.showStatus)
+	b.ws.window.SwapBuffers()
+}
+
+func (b *glfwBackend) SetStatus(s emulatorStatus) {
+	if s.rom != b.status.rom || s.state != b.status.state {
+		title := "Chip8 Emulator"
+		if s.rom != "" {
+			title += " - " + s.rom
+		}
+		if s.state != "" {
+			title += " [" + s.state + "]"
+		}
+		b.ws.window.SetTitle(title)
+	}
+	b.status = s
+}
+
+func (b *glfwBackend) SetKeypad(keys [16]byte, polled [16]bool) {
+	b.keypad.update(keys, polled)
+}
+
+func (b *glfwBackend) SetROM(rom *romSetup) {
+	b.keymap = rom.keymap
+	b.palette = rom.palette
+	b.renderer.palette = rom.palette
+	b.menu.canClose = true
+}
+
+func (b *glfwBackend) OpenMenu() {
+	b.menu.show()
+}
+
+func (b *glfwBackend) MenuOpen() bool {
+	return b.menu.open
+}
+
+func (b *glfwBackend) ChosenROM() (string, bool) {
+	path := b.menu.chosen
+	if b.dropped != "" {
+		path = b.dropped
+	}
+	b.menu.chosen, b.dropped = "", ""
+	return path, path != ""
+}
+
+func (b *glfwBackend) ShouldClose() bool {
+	return b.ws.window.ShouldClose()
+}
+
+func (b *glfwBackend) Close() {
+	glfw.Terminate()
+}
+
+func (b *glfwBackend) Update(keys *[16]byte) {
+	glfw.PollEvents()
+	b.keys.apply(keys)
+	readGamepads(b.gamepads, b.keymap, keys)
+}
+
+func on_keyboard_pressed(b *glfwBackend, key glfw.Key, scancode int, action glfw.Action) {
+	if key == glfw.KeyEscape && action == glfw.Press {
+		b.ws.window.SetShouldClose(true)
+		return
+	}
+
+	if symbol, ok := b.keymap.lookup(key, scancode); ok {
+		b.keys.push(symbol, true)
+	}
+}
+
+func on_keyboard_released(b *glfwBackend, key glfw.Key, scancode int, action glfw.Action) {
+	if symbol, ok := b.keymap.lookup(key, scancode); ok {
+		b.keys.push(symbol, false)
+	}
+}
+
+func keyboardHandler(b *glfwBackend) {
+	b.ws.window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		if h, ok := b.hotkeys[key]; ok {
+			if action == glfw.Press {
+				on_hotkey(b, h, mods)
+			} else if action == glfw.Release && h == hotkeyFastForward {
+				b.fastForward = false
+			}
+			return
+		}
+
+		// The ROM menu takes the keys while it is open, Escape still quits
+		// when there is no game to go back to
+		if b.menu.open && action != glfw.Release && (key != glfw.KeyEscape || b.menu.canClose) {
+			_, _, rows := menuLayout(b.ws)
+			b.menu.handleKey(key, rows)
+			return
+		}
+
+		switch action {
+		case glfw.Press:
+			on_keyboard_pressed(b, key, scancode, action)
+		case glfw.Release:
+			on_keyboard_released(b, key, scancode, action)
+		}
+	})
+}
+
+func on_hotkey(b *glfwBackend, h hotkey, mods glfw.ModifierKey) {
+	switch h {
+	case hotkeyMenu:
+		b.menu.show()
+	case hotkeyKeypad:
+		b.ws.keypad = !b.ws.keypad
+		b.ws.applyViewport()
+	case hotkeyStatus:
+		b.showStatus = !b.showStatus
+	case hotkeyRecord:
+		b.recorder.toggle()
+	case hotkeyScreenshot:
+		b.renderer.screenshot(&b.last)
+	case hotkeyFullscreen:
+		// With Shift it selects the next monitor
+		if mods&glfw.ModShift != 0 {
+			b.ws.nextMonitor()
+		} else {
+			b.ws.toggleFullscreen()
+		}
+	case hotkeyFastForward:
+		b.fastForward = true
+	default:
+		// Handled by the emulator, see Controls
+		b.controls = append(b.controls, h)
+	}
+}
+
+func (b *glfwBackend) Controls() ([]hotkey, bool) {
+	pressed := b.controls
+	b.controls = nil
+	return pressed, b.fastForward
+}
+
+// dropHandler loads the ROMs dropped on the window.
+func dropHandler(b *glfwBackend) {
+	b.ws.window.SetDropCallback(func(w *glfw.Window, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		if len(names) > 1 {
+			notify("%d files dropped, loading the first one", len(names))
+		}
+		path := names[0]
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == archiveExtension {
+			// A library, unless it has only one ROM
+			if entry := b.menu.loader.archiveEntry(path); entry.library {
+				b.menu.dir = path
+				b.menu.show()
+				return
+			}
+		} else if _, ok := romExtensions[ext]; !ok && ext != "" {
+			notify("Can't load %s: unsupported file type, use .ch8, .sc8, .xo8, .8o, .gif or .zip", filepath.Base(path))
+			return
+		}
+		b.dropped = path
+		b.menu.open = false
+	})
+}
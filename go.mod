@@ -3,6 +3,6 @@ module main.go
 go 1.24.5
 
 require (
-	github.com/go-gl/gl v0.0.0-20231021071112-07e5d0ea2e71 // indirect
-	github.com/go-gl/glfw v0.0.0-20250301202403-da16c1255728 // indirect
+	github.com/go-gl/gl v0.0.0-20231021071112-07e5d0ea2e71
+	github.com/go-gl/glfw v0.0.0-20250301202403-da16c1255728
 )
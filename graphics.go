@@ -12,7 +12,7 @@ import (
 
 	"runtime"
 
-	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 )
 
@@ -33,24 +33,12 @@ const (
 
 	fragmentShaderSource = `
 		#version 410
+		uniform float intensity;
+		uniform vec3 background;
+		uniform vec3 foreground;
 		out vec4 frag_colour;
 		void main() {
-			frag_colour = vec4(1, 1, 1, 1.0);
-		}
-	` + "\x00"
-
-	vertexShaderSourceV2 = `
-		#version 120
-		attribute vec3 vp;
-		void main() {
-			gl_Position = vec4(vp, 1.0);
-		}
-	` + "\x00"
-
-	fragmentShaderSourceV2 = `
-		#version 120
-		void main() {
-			gl_FragColor = vec4(1, 1, 1, 1.0);
+			frag_colour = vec4(mix(background, foreground, intensity), 1.0);
 		}
 	` + "\x00"
 
@@ -64,9 +52,12 @@ const (
 
 	fragmentShaderSourceV3 = `
 		#version 330 core
+		uniform float intensity;
+		uniform vec3 background;
+		uniform vec3 foreground;
 		out vec4 frag_colour;
 		void main() {
-			frag_colour = vec4(1, 1, 1, 1.0);
+			frag_colour = vec4(mix(background, foreground, intensity), 1.0);
 		}
 	` + "\x00"
 )
@@ -104,134 +95,156 @@ type cell struct {
 // 	}
 // }
 
-func initWindowEmulator() *glfw.Window {
-	runtime.LockOSThread()
-	// Initialize GLFW
-	return initGlfw()
+// glContext is one of the OpenGL versions we know how to draw with.
+type glContext struct {
+	major, minor   int
+	core           bool // Core profile, forward compatible (required by macOS)
+	vertexShader   string
+	fragmentShader string
 }
 
-func initGlfw() *glfw.Window {
-	if err := glfw.Init(); err != nil {
-		panic(fmt.Errorf("failed to initialize GLFW: %v", err))
-	}
-	// glfw.WindowHint(glfw.ContextVersionMajor, 4)
-	// glfw.WindowHint(glfw.ContextVersionMinor, 1)
-	// glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-	// glfw.WindowHint(glfw.Resizable, glfw.False)
-	// glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
-
-	// Create a windowed mode window and its OpenGL context
-	window, err := glfw.CreateWindow(WIDTH*10, HEIGHT*10, "Chip8 Emulator", nil, nil)
-	if err != nil {
-		panic(fmt.Errorf("failed to create window: %v", err))
+func (c glContext) String() string {
+	if c.core {
+		return fmt.Sprintf("%d.%d core", c.major, c.minor)
 	}
+	return fmt.Sprintf("%d.%d", c.major, c.minor)
+}
 
-	window.MakeContextCurrent()
-	return window
+// Contexts requested in order, the first one that works is used. The GL
+// bindings are the ones of 3.3 core, gl.Init fails on anything older.
+var glContexts = []glContext{
+	{4, 1, true, vertexShaderSource, fragmentShaderSource},
+	{3, 3, true, vertexShaderSourceV3, fragmentShaderSourceV3},
 }
 
-func initOpenGL() uint32 {
-	var major int
-	major, _, _ = glfw.GetVersion()
-	if major < 3 {
-		println("OpenGL version 2 found")
-		return initOpenGL2()
-	} else if major == 3 {
-		return initOpenGL3()
-	} else {
-		println("OpenGL version 3 or higher found")
-		return initOpenGL4()
-	}
+// glInfo describes the context that was actually created.
+type glInfo struct {
+	requested    glContext
+	version      string // GL_VERSION as reported by the driver
+	major, minor int
+}
 
+func (i glInfo) atLeast(major, minor int) bool {
+	return i.major > major || (i.major == major && i.minor >= minor)
 }
 
-func initOpenGL3() uint32 {
-	if err := gl.Init(); err != nil {
-		panic(fmt.Errorf("failed to initialize OpenGL: %v", err))
+func initWindowEmulator(width, height int) (*glfw.Window, uint32, glInfo, error) {
+	runtime.LockOSThread()
+	// Initialize GLFW
+	return initGlfw(width, height)
+}
+
+// initGlfw creates the window with the newest OpenGL context available. For
+// every context of glContexts it creates a window, checks the GL_VERSION we
+// really got and compiles the shaders for it. When nothing works the error
+// lists what happened with every attempt.
+func initGlfw(width, height int) (*glfw.Window, uint32, glInfo, error) {
+	if err := glfw.Init(); err != nil {
+		// Usually no display, like over SSH or in a container
+		return nil, 0, glInfo{}, fmt.Errorf("can't open a window (%v), without a display use -frontend terminal or -headless", err)
 	}
 
-	version := gl.GoStr(gl.GetString(gl.VERSION))
-	fmt.Printf("OpenGL version: %s\n", version)
+	var attempts []string
+	for _, ctx := range glContexts {
+		glfw.DefaultWindowHints()
+		glfw.WindowHint(glfw.ContextVersionMajor, ctx.major)
+		glfw.WindowHint(glfw.ContextVersionMinor, ctx.minor)
+		if ctx.core {
+			glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+			glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+		}
 
-	vertexShader, err := compileShader(vertexShaderSourceV3, gl.VERTEX_SHADER)
-	if err != nil {
-		panic(fmt.Errorf("failed to compile vertex shader: %v", err))
-	}
-	fragmentShader, err := compileShader(fragmentShaderSourceV3, gl.FRAGMENT_SHADER)
-	if err != nil {
-		panic(fmt.Errorf("failed to compile fragment shader: %v", err))
+		// Create a windowed mode window and its OpenGL context
+		window, err := glfw.CreateWindow(width, height, "Chip8 Emulator", nil, nil)
+		if err != nil {
+			attempts = append(attempts, fmt.Sprintf("OpenGL %v: %v", ctx, err))
+			continue
+		}
+		window.MakeContextCurrent()
+
+		info, program, err := initOpenGL(ctx)
+		if err != nil {
+			attempts = append(attempts, fmt.Sprintf("OpenGL %v: %v", ctx, err))
+			window.Destroy()
+			continue
+		}
+		fmt.Printf("OpenGL version: %s (requested %v)\n", info.version, ctx)
+		return window, program, info, nil
 	}
-	prog := gl.CreateProgram()
-	gl.AttachShader(prog, vertexShader)
-	gl.AttachShader(prog, fragmentShader)
-	gl.LinkProgram(prog)
-	return prog
+
+	return nil, 0, glInfo{}, fmt.Errorf("no usable OpenGL context:\n  %s", strings.Join(attempts, "\n  "))
 }
 
-func initOpenGL2() uint32 {
+// initOpenGL loads OpenGL in the current context and builds the program for
+// the version we got. Drivers often give a newer version than requested, so
+// the shaders are chosen from the reported version and not from the request.
+func initOpenGL(requested glContext) (glInfo, uint32, error) {
 	if err := gl.Init(); err != nil {
-		panic(fmt.Errorf("failed to initialize OpenGL: %v", err))
+		return glInfo{}, 0, fmt.Errorf("failed to initialize OpenGL: %v", err)
 	}
 
-	version := gl.GoStr(gl.GetString(gl.VERSION))
-	fmt.Printf("OpenGL version: %s\n", version)
+	info := glInfo{requested: requested, version: gl.GoStr(gl.GetString(gl.VERSION))}
+	if _, err := fmt.Sscanf(strings.TrimPrefix(info.version, "OpenGL ES "), "%d.%d", &info.major, &info.minor); err != nil {
+		return info, 0, fmt.Errorf("can't read GL_VERSION %q", info.version)
+	}
 
-	vertexShader, err := compileShader(vertexShaderSourceV2, gl.VERTEX_SHADER)
-	if err != nil {
-		panic(fmt.Errorf("failed to compile vertex shader: %v", err))
+	var errs []string
+	for _, ctx := range glContexts {
+		if !info.atLeast(ctx.major, ctx.minor) {
+			continue
+		}
+		program, err := newProgram(ctx.vertexShader, ctx.fragmentShader)
+		if err != nil {
+			// Try the shaders of an older version
+			errs = append(errs, fmt.Sprintf("shaders for %v: %v", ctx, err))
+			continue
+		}
+		return info, program, nil
 	}
-	fragmentShader, err := compileShader(fragmentShaderSourceV2, gl.FRAGMENT_SHADER)
-	if err != nil {
-		panic(fmt.Errorf("failed to compile fragment shader: %v", err))
+	if len(errs) == 0 {
+		return info, 0, fmt.Errorf("OpenGL %s is too old, 3.3 is needed", info.version)
 	}
-	prog := gl.CreateProgram()
-	gl.AttachShader(prog, vertexShader)
-	gl.AttachShader(prog, fragmentShader)
-	gl.LinkProgram(prog)
-	return prog
+	return info, 0, fmt.Errorf("got OpenGL %s but %s", info.version, strings.Join(errs, "; "))
 }
 
-func initOpenGL4() uint32 {
-	if err := gl.Init(); err != nil {
-		panic(fmt.Errorf("failed to initialize OpenGL: %v", err))
-	}
-
-	version := gl.GoStr(gl.GetString(gl.VERSION))
-	fmt.Printf("OpenGL version: %s\n", version)
-
-	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
+func newProgram(vertexSource, fragmentSource string) (uint32, error) {
+	vertexShader, err := compileShader(vertexSource, gl.VERTEX_SHADER)
 	if err != nil {
-		panic(fmt.Errorf("failed to compile vertex shader: %v", err))
+		return 0, fmt.Errorf("failed to compile vertex shader: %v", err)
 	}
-	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
+	defer gl.DeleteShader(vertexShader)
+	fragmentShader, err := compileShader(fragmentSource, gl.FRAGMENT_SHADER)
 	if err != nil {
-		panic(fmt.Errorf("failed to compile fragment shader: %v", err))
+		return 0, fmt.Errorf("failed to compile fragment shader: %v", err)
 	}
-	prog := gl.CreateProgram()
-	gl.AttachShader(prog, vertexShader)
-	gl.AttachShader(prog, fragmentShader)
-	gl.LinkProgram(prog)
-	return prog
+	// The program keeps the compiled code, the shaders aren't needed after
+	// linking
+	defer gl.DeleteShader(fragmentShader)
+	return linkProgram(vertexShader, fragmentShader)
 }
 
 func drawGraphics(c *cpu, window *glfw.Window, program uint32) {
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 	gl.UseProgram(program)
-	// Draw the graphics
+	setPalette(program, defaultPalette)
+	setIntensity(program, 1)
+	// Draw the graphics, all the pixels in one buffer
+	var vertices []float32
 	for y := 0; y < HEIGHT; y++ {
 		for x := 0; x < WIDTH; x++ {
 			if c.gfx[y*WIDTH+x] != 0 {
-				// Draw a pixel at (x, y)
-				drawPixel(x, y, program)
+				vertices = appendPixel(vertices, x, y)
 			}
 		}
 	}
+	drawVertices(vertices)
 
 	window.SwapBuffers()
 	c.drawFlag = false // Reset the draw flag after drawing
 }
 
-func drawPixel(x, y int, program uint32) {
+// appendPixel adds the two triangles of the pixel at (x, y) to vertices.
+func appendPixel(vertices []float32, x, y int) []float32 {
 	// Convertir coordenadas CHIP-8 (0-63, 0-31) a OpenGL (-1 a 1)
 	pixelWidth := 2.0 / float32(WIDTH)
 	pixelHeight := 2.0 / float32(HEIGHT)
@@ -244,7 +257,7 @@ func drawPixel(x, y int, program uint32) {
 	endX := startX + pixelWidth
 	endY := startY + pixelHeight
 
-	vertices := []float32{
+	return append(vertices,
 		startX, endY, 0.0, // Bottom left
 		endX, endY, 0.0, // Bottom right
 		endX, startY, 0.0, // Top right
@@ -252,12 +265,41 @@ func drawPixel(x, y int, program uint32) {
 		startX, endY, 0.0, // Bottom left
 		endX, startY, 0.0, // Top right
 		startX, startY, 0.0, // Top left
-	}
+	)
+}
 
-	vao := makeVao(vertices)
+// drawVertices draws triangles from a buffer that only lives for this draw.
+func drawVertices(vertices []float32) {
+	if len(vertices) == 0 {
+		return
+	}
+	var vbo, vao uint32
+	gl.GenBuffers(1, &vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.STREAM_DRAW)
+	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
-	gl.DrawArrays(gl.TRIANGLES, 0, 6)
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 0, nil)
+
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(vertices)/3))
+
 	gl.DeleteVertexArrays(1, &vao)
+	gl.DeleteBuffers(1, &vbo)
+}
+
+func setIntensity(program uint32, intensity float32) {
+	gl.Uniform1f(uniformLocation(program, "intensity"), intensity)
+}
+
+func setPalette(program uint32, pal palette) {
+	bg, fg := pal.background, pal.foreground
+	gl.Uniform3f(uniformLocation(program, "background"), float32(bg.R)/255, float32(bg.G)/255, float32(bg.B)/255)
+	gl.Uniform3f(uniformLocation(program, "foreground"), float32(fg.R)/255, float32(fg.G)/255, float32(fg.B)/255)
+}
+
+func uniformLocation(program uint32, name string) int32 {
+	return gl.GetUniformLocation(program, gl.Str(name+"\x00"))
 }
 
 func makeVao(points []float32) uint32 {
@@ -276,20 +318,44 @@ func makeVao(points []float32) uint32 {
 	return vao
 }
 
-func drawSpriteOnWindow(window *glfw.Window, program uint32, cpu *cpu) {
+// drawSpriteOnWindow draws the brightness computed by the screen filter
+// instead of reading cpu.gfx directly, so fading pixels are drawn dimmer.
+func drawSpriteOnWindow(ws *windowState, program uint32, filter *screenFilter, post *postPipeline, pal palette) {
+	ws.clear()
+	vp := ws.viewport()
+	postProcessing := post.begin(vp)
+
+	// Fill the screen area with the background color, the bars stay black
+	bg := pal.background
+	gl.ClearColor(float32(bg.R)/255, float32(bg.G)/255, float32(bg.B)/255, 1)
+	if !postProcessing {
+		gl.Enable(gl.SCISSOR_TEST)
+		gl.Scissor(vp.x, vp.y, vp.width, vp.height)
+	}
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.ClearColor(0, 0, 0, 1)
+
 	gl.UseProgram(program)
+	setPalette(program, pal)
 
+	// One buffer for all the pixels with the same brightness
+	batches := map[float32][]float32{}
 	for y := 0; y < HEIGHT; y++ {
 		for x := 0; x < WIDTH; x++ {
-			if cpu.gfx[y*WIDTH+x] != 0 {
-				drawPixel(x, y, program)
+			if b := filter.brightness[y*WIDTH+x]; b > 0 {
+				batches[b] = appendPixel(batches[b], x, y)
 			}
 		}
 	}
+	for b, vertices := range batches {
+		setIntensity(program, b)
+		drawVertices(vertices)
+	}
 
-	glfw.PollEvents()
-	window.SwapBuffers()
+	if postProcessing {
+		post.end(vp)
+	}
 }
 
 func compileShader(source string, shaderType uint32) (uint32, error) {
@@ -309,12 +375,35 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
 
+		gl.DeleteShader(shader)
 		return 0, fmt.Errorf("failed to compile shader: %s", log)
 	}
 
 	return shader, nil
 }
 
+func linkProgram(vertexShader, fragmentShader uint32) (uint32, error) {
+	prog := gl.CreateProgram()
+	gl.AttachShader(prog, vertexShader)
+	gl.AttachShader(prog, fragmentShader)
+	gl.LinkProgram(prog)
+
+	var status int32
+	gl.GetProgramiv(prog, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(prog, gl.INFO_LOG_LENGTH, &logLength)
+
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetProgramInfoLog(prog, logLength, nil, gl.Str(log))
+
+		gl.DeleteProgram(prog)
+		return 0, fmt.Errorf("failed to link program: %s", log)
+	}
+
+	return prog, nil
+}
+
 func makeCells() [][]*cell {
 	cells := make([][]*cell, ROWS, ROWS)
 	for x := range ROWS {
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Keypad input.
+
+The window callbacks don't write the keypad directly, they queue the presses
+and releases with the time they happened. The queue is applied once per
+frame, before the instructions of the frame run, so the keypad never changes
+in the middle of a frame. A key that is pressed and released between two
+frames stays pressed for one frame, otherwise EX9E/EXA1 would never see it.
+
+The keypad state of every emulated frame can be saved to a file and played
+back later with -input-record and -input-replay. The file also keeps the
+random seed, the instructions per frame and the resets and ROMs loaded, with
+them a replay runs exactly like the recorded game:
+
+	seed 1700000000
+	0 ipf 10
+	120 5 down
+	128 5 up
+	300 reset
+	450 load games/BRIX
+	450 ipf 15
+
+Each event line starts with the number of the emulated frame, frames shown
+while paused or in the menu don't count, so pausing, fast forward and slow
+motion don't change the replay. Key events have the CHIP-8 key and down or
+up.
+*/
+
+type keyEvent struct {
+	at      time.Duration // Time since the queue was created
+	key     byte
+	pressed bool
+}
+
+// keyQueue collects key events between frames. Only used from the main
+// thread, the GLFW callbacks run inside glfw.PollEvents.
+type keyQueue struct {
+	start   time.Time
+	pending []keyEvent
+	state   [16]byte
+}
+
+func newKeyQueue() *keyQueue {
+	return &keyQueue{start: time.Now()}
+}
+
+func (q *keyQueue) push(key byte, pressed bool) {
+	q.pending = append(q.pending, keyEvent{at: time.Since(q.start), key: key, pressed: pressed})
+}
+
+// apply writes in keys the state after the queued events. The release of a
+// key pressed in this same frame, and everything after it for that key, is
+// left in the queue for the next frame.
+func (q *keyQueue) apply(keys *[16]byte) {
+	var pressedNow, deferred [16]bool
+	var rest []keyEvent
+	for _, ev := range q.pending {
+		if deferred[ev.key] || (!ev.pressed && pressedNow[ev.key]) {
+			deferred[ev.key] = true
+			rest = append(rest, ev)
+			continue
+		}
+		if ev.pressed {
+			if q.state[ev.key] == 0 {
+				pressedNow[ev.key] = true
+			}
+			q.state[ev.key] = 1
+		} else {
+			q.state[ev.key] = 0
+		}
+	}
+	q.pending = rest
+	*keys = q.state
+}
+
+// inputRecorder saves the keypad state of every emulated frame, and the
+// changes of ROM and speed, to a file.
+type inputRecorder struct {
+	f    *os.File
+	w    *bufio.Writer
+	last [16]byte
+}
+
+func newInputRecorder(path string, seed int64) (*inputRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &inputRecorder{f: f, w: bufio.NewWriter(f)}
+	fmt.Fprintf(r.w, "seed %d\n", seed)
+	return r, nil
+}
+
+// keys saves the keys that changed since the last emulated frame.
+func (r *inputRecorder) keys(frame int, keys *[16]byte) {
+	for k := range keys {
+		if keys[k] == r.last[k] {
+			continue
+		}
+		action := "up"
+		if keys[k] != 0 {
+			action = "down"
+		}
+		fmt.Fprintf(r.w, "%d %X %s\n", frame, k, action)
+	}
+	r.last = *keys
+}
+
+// event saves a change of the emulator before the frame runs, like "reset"
+// or "ipf 15".
+func (r *inputRecorder) event(frame int, format string, args ...any) {
+	fmt.Fprintf(r.w, "%d %s\n", frame, fmt.Sprintf(format, args...))
+}
+
+func (r *inputRecorder) close() {
+	if err := r.w.Flush(); err != nil {
+		fmt.Printf("Error saving input recording: %v\n", err)
+	}
+	r.f.Close()
+}
+
+// recordedEvent is a line of the recording. Key events have an empty
+// action.
+type recordedEvent struct {
+	frame   int
+	key     byte
+	pressed bool
+	action  string // reset, reload, load or ipf
+	path    string // ROM of load
+	ipf     int
+}
+
+// replayInput plays back a file saved by inputRecorder.
+type replayInput struct {
+	seed   int64
+	events []recordedEvent
+	next   int
+	state  [16]byte
+}
+
+func loadInputReplay(path string) (*replayInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &replayInput{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) == 2 && fields[0] == "seed" {
+			r.seed, err = strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			continue
+		}
+		if len(fields) == 2 && fields[0] == "ipf" {
+			// Older recordings have the speed at the start
+			fields = []string{"0", "ipf", fields[1]}
+		}
+
+		var ev recordedEvent
+		ev.frame, err = strconv.Atoi(fields[0])
+		switch {
+		case err != nil:
+		case len(fields) == 3 && (fields[2] == "down" || fields[2] == "up"):
+			var key uint64
+			key, err = strconv.ParseUint(fields[1], 16, 4)
+			ev.key, ev.pressed = byte(key), fields[2] == "down"
+		case len(fields) == 2 && (fields[1] == "reset" || fields[1] == "reload"):
+			ev.action = fields[1]
+		case len(fields) == 3 && fields[1] == "ipf":
+			ev.action = "ipf"
+			ev.ipf, err = strconv.Atoi(fields[2])
+		case len(fields) >= 3 && fields[1] == "load":
+			// The path is the rest of the line, it can have spaces
+			text := scanner.Text()
+			ev.action = "load"
+			ev.path = strings.TrimSpace(text[strings.Index(text, " load ")+len(" load "):])
+		default:
+			err = fmt.Errorf("unknown line %q", scanner.Text())
+		}
+		if err == nil && len(r.events) > 0 && ev.frame < r.events[len(r.events)-1].frame {
+			err = fmt.Errorf("frames out of order")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		r.events = append(r.events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// frame returns the events of the emulated frame about to run, other than
+// keys, and writes in keys the keypad state for it.
+func (r *replayInput) frame(frame int, keys *[16]byte) []recordedEvent {
+	var events []recordedEvent
+	for r.next < len(r.events) && r.events[r.next].frame <= frame {
+		ev := r.events[r.next]
+		switch {
+		case ev.action != "":
+			events = append(events, ev)
+		case ev.pressed:
+			r.state[ev.key] = 1
+		default:
+			r.state[ev.key] = 0
+		}
+		r.next++
+	}
+	*keys = r.state
+	return events
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestKeyQueueKeepsShortPresses(t *testing.T) {
+	q := newKeyQueue()
+	q.push(0x5, true)
+	q.push(0x5, false)
+	q.push(0x1, true)
+
+	var keys [16]byte
+	q.apply(&keys)
+	if want := pressed(0x5, 0x1); keys != want {
+		t.Errorf("first frame %v, want the short press seen", keys)
+	}
+	q.apply(&keys)
+	if want := pressed(0x1); keys != want {
+		t.Errorf("second frame %v, want 5 released", keys)
+	}
+}
+
+func TestKeyQueueTimestamps(t *testing.T) {
+	q := newKeyQueue()
+	q.push(0x5, true)
+	q.push(0x5, false)
+	if len(q.pending) != 2 || q.pending[1].at < q.pending[0].at {
+		t.Errorf("events %+v, want them in the order they happened", q.pending)
+	}
+}
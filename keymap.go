@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+Key bindings.
+
+The bindings are read from a JSON file with a default map and overrides for
+single ROMs, found by the SHA-1 of the ROM:
+
+	{
+		"default": {"5": ["W", "Up"], "8": ["S", "Down"]},
+		"roms": {
+			"<sha1 of the ROM>": {"name": "PONG", "keys": {"1": ["W"], "4": ["S"]}}
+		}
+	}
+
+The keys of each map are the CHIP-8 keys (0-F) and every CHIP-8 key can have
+several physical keys. A ROM override replaces the bindings of the CHIP-8
+keys it lists and keeps the rest of the default map. Without "default" the
+built-in KEY_MAP is used.
+
+Physical keys are written as:
+
+	"Q", "Up", "Space", "KP5"  GLFW key, named after its place on a US keyboard
+	"scancode:24"              Scancode of the key, independent of the layout
+	"char:a"                   Key that prints this character in the current layout
+	"button:0", "axis:1-"      Controller button or axis, see gamepad.go
+*/
+
+// Names of the GLFW keys that can be used in the bindings, letters, digits
+// and F1-F12 are added by init.
+var keyNames = map[string]glfw.Key{
+	"space":        glfw.KeySpace,
+	"enter":        glfw.KeyEnter,
+	"tab":          glfw.KeyTab,
+	"backspace":    glfw.KeyBackspace,
+	"insert":       glfw.KeyInsert,
+	"delete":       glfw.KeyDelete,
+	"up":           glfw.KeyUp,
+	"down":         glfw.KeyDown,
+	"left":         glfw.KeyLeft,
+	"right":        glfw.KeyRight,
+	"home":         glfw.KeyHome,
+	"end":          glfw.KeyEnd,
+	"pageup":       glfw.KeyPageUp,
+	"pagedown":     glfw.KeyPageDown,
+	"leftshift":    glfw.KeyLeftShift,
+	"rightshift":   glfw.KeyRightShift,
+	"leftcontrol":  glfw.KeyLeftControl,
+	"rightcontrol": glfw.KeyRightControl,
+	"leftalt":      glfw.KeyLeftAlt,
+	"rightalt":     glfw.KeyRightAlt,
+	"comma":        glfw.KeyComma,
+	"period":       glfw.KeyPeriod,
+	"slash":        glfw.KeySlash,
+	"semicolon":    glfw.KeySemicolon,
+	"apostrophe":   glfw.KeyApostrophe,
+	"minus":        glfw.KeyMinus,
+	"equal":        glfw.KeyEqual,
+	"backslash":    glfw.KeyBackslash,
+	"leftbracket":  glfw.KeyLeftBracket,
+	"rightbracket": glfw.KeyRightBracket,
+	"graveaccent":  glfw.KeyGraveAccent,
+	"kpadd":        glfw.KeyKPAdd,
+	"kpsubtract":   glfw.KeyKPSubtract,
+	"kpmultiply":   glfw.KeyKPMultiply,
+	"kpdivide":     glfw.KeyKPDivide,
+	"kpenter":      glfw.KeyKPEnter,
+	"kpdecimal":    glfw.KeyKPDecimal,
+	"pause":        glfw.KeyPause,
+}
+
+func init() {
+	for k := glfw.KeyA; k <= glfw.KeyZ; k++ {
+		keyNames[strings.ToLower(string(rune(k)))] = k
+	}
+	for i := 0; i <= 9; i++ {
+		keyNames[strconv.Itoa(i)] = glfw.Key0 + glfw.Key(i)
+		keyNames["kp"+strconv.Itoa(i)] = glfw.KeyKP0 + glfw.Key(i)
+	}
+	for i := 1; i <= 12; i++ {
+		keyNames["f"+strconv.Itoa(i)] = glfw.KeyF1 + glfw.Key(i-1)
+	}
+}
+
+type keyConfig struct {
+	Default  map[string][]string     `json:"default"`
+	DeadZone float32                 `json:"deadzone"` // 0-1, for the controller axes
+	ROMs     map[string]romKeyConfig `json:"roms"`
+	Hotkeys  map[string][]string     `json:"hotkeys"` // See control.go
+}
+
+type romKeyConfig struct {
+	Name     string              `json:"name"` // Only to help reading the file
+	Keys     map[string][]string `json:"keys"`
+	DeadZone float32             `json:"deadzone"`
+}
+
+func loadKeyConfig(path string) (keyConfig, error) {
+	var cfg keyConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// keyMap translates physical keys and controller inputs to CHIP-8 keys.
+type keyMap struct {
+	keys      map[glfw.Key]byte
+	scancodes map[int]byte
+	chars     map[string]byte
+	buttons   map[int]byte
+	axes      map[axisDirection]byte
+	deadZone  float32
+}
+
+func newKeyMap() *keyMap {
+	return &keyMap{
+		keys:      map[glfw.Key]byte{},
+		scancodes: map[int]byte{},
+		chars:     map[string]byte{},
+		buttons:   map[int]byte{},
+		axes:      map[axisDirection]byte{},
+		deadZone:  defaultDeadZone,
+	}
+}
+
+// buildKeyMap returns the bindings for the ROM with the given SHA-1. The
+// extra bindings, from the ROM database, are added to the default map.
+func buildKeyMap(cfg keyConfig, romHash string, extra map[byte][]string) (*keyMap, error) {
+	m := newKeyMap()
+	if len(cfg.Default) == 0 {
+		for k, v := range KEY_MAP {
+			m.keys[k] = v
+		}
+		for chip8Key, inputs := range defaultGamepadBindings {
+			for _, name := range inputs {
+				m.bindGamepad(name, chip8Key)
+			}
+		}
+	} else if err := m.apply(cfg.Default); err != nil {
+		return nil, fmt.Errorf("default key bindings: %v", err)
+	}
+
+	for symbol, names := range extra {
+		for _, name := range names {
+			if err := m.bind(name, symbol); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.DeadZone != 0 {
+		m.deadZone = cfg.DeadZone
+	}
+
+	if rom, ok := cfg.ROMs[strings.ToLower(romHash)]; ok {
+		if err := m.apply(rom.Keys); err != nil {
+			return nil, fmt.Errorf("key bindings of %s: %v", rom.Name, err)
+		}
+		if rom.DeadZone != 0 {
+			m.deadZone = rom.DeadZone
+		}
+	}
+	if m.deadZone < 0 || m.deadZone >= 1 {
+		return nil, fmt.Errorf("dead zone must be between 0 and 1, got %v", m.deadZone)
+	}
+	return m, nil
+}
+
+// apply binds the physical keys of each listed CHIP-8 key, replacing the
+// bindings it had before.
+func (m *keyMap) apply(bindings map[string][]string) error {
+	for chip8Key, physical := range bindings {
+		symbol, err := strconv.ParseUint(chip8Key, 16, 4)
+		if err != nil {
+			return fmt.Errorf("%q is not a CHIP-8 key (0-F)", chip8Key)
+		}
+		m.unbind(byte(symbol))
+		for _, name := range physical {
+			if err := m.bind(name, byte(symbol)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *keyMap) unbind(symbol byte) {
+	for k, v := range m.keys {
+		if v == symbol {
+			delete(m.keys, k)
+		}
+	}
+	for k, v := range m.scancodes {
+		if v == symbol {
+			delete(m.scancodes, k)
+		}
+	}
+	for k, v := range m.chars {
+		if v == symbol {
+			delete(m.chars, k)
+		}
+	}
+	for k, v := range m.buttons {
+		if v == symbol {
+			delete(m.buttons, k)
+		}
+	}
+	for k, v := range m.axes {
+		if v == symbol {
+			delete(m.axes, k)
+		}
+	}
+}
+
+func (m *keyMap) bind(name string, symbol byte) error {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, "scancode:"):
+		scancode, err := strconv.Atoi(strings.TrimPrefix(lower, "scancode:"))
+		if err != nil {
+			return fmt.Errorf("invalid scancode in %q", name)
+		}
+		m.scancodes[scancode] = symbol
+	case strings.HasPrefix(lower, "char:"):
+		char := strings.TrimPrefix(lower, "char:")
+		if char == "" {
+			return fmt.Errorf("missing character in %q", name)
+		}
+		m.chars[char] = symbol
+	case strings.HasPrefix(lower, "button:"), strings.HasPrefix(lower, "axis:"):
+		return m.bindGamepad(lower, symbol)
+	default:
+		key, ok := keyNames[lower]
+		if !ok {
+			return fmt.Errorf("unknown key %q", name)
+		}
+		m.keys[key] = symbol
+	}
+	return nil
+}
+
+// lookup finds the CHIP-8 key of a GLFW key event. Scancodes are checked
+// first, then the printed character and then the key.
+func (m *keyMap) lookup(key glfw.Key, scancode int) (byte, bool) {
+	if symbol, ok := m.scancodes[scancode]; ok {
+		return symbol, true
+	}
+	if len(m.chars) > 0 {
+		if symbol, ok := m.chars[strings.ToLower(glfw.GetKeyName(key, scancode))]; ok {
+			return symbol, true
+		}
+	}
+	symbol, ok := m.keys[key]
+	return symbol, ok
+}
+
+// lookupChar finds the CHIP-8 key of a character typed in the terminal.
+func (m *keyMap) lookupChar(r rune) (byte, bool) {
+	if symbol, ok := m.chars[strings.ToLower(string(r))]; ok {
+		return symbol, true
+	}
+	// Printable keys have the same code in GLFW as in uppercase ASCII
+	symbol, ok := m.keys[glfw.Key(unicode.ToUpper(r))]
+	return symbol, ok
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+func TestBuildKeyMapDefaults(t *testing.T) {
+	m, err := buildKeyMap(keyConfig{}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if symbol, ok := m.keys[glfw.KeyW]; !ok || symbol != 0x5 {
+		t.Errorf("W is bound to %X (%v), want 5", symbol, ok)
+	}
+	if symbol, ok := m.buttons[0]; !ok || symbol != 0x5 {
+		t.Errorf("button 0 is bound to %X (%v), want 5", symbol, ok)
+	}
+	if m.deadZone != defaultDeadZone {
+		t.Errorf("dead zone %v, want %v", m.deadZone, defaultDeadZone)
+	}
+}
+
+func TestBuildKeyMapDefaultReplacesBuiltIn(t *testing.T) {
+	cfg := keyConfig{Default: map[string][]string{"5": {"Up", "scancode:24"}}}
+	m, err := buildKeyMap(cfg, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.keys[glfw.KeyW]; ok {
+		t.Error("W is still bound with a default map in the config")
+	}
+	if len(m.buttons) != 0 || len(m.axes) != 0 {
+		t.Error("the controller is still bound with a default map in the config")
+	}
+	if symbol, ok := m.lookup(glfw.KeyUnknown, 24); !ok || symbol != 0x5 {
+		t.Errorf("scancode 24 is bound to %X (%v), want 5", symbol, ok)
+	}
+	if symbol := m.keys[glfw.KeyUp]; symbol != 0x5 {
+		t.Errorf("Up is bound to %X, want 5", symbol)
+	}
+}
+
+func TestBuildKeyMapROMBindings(t *testing.T) {
+	const hash = "ABCDEF0123"
+	cfg := keyConfig{
+		DeadZone: 0.3,
+		ROMs: map[string]romKeyConfig{
+			strings.ToLower(hash): {Name: "PONG", Keys: map[string][]string{"1": {"Up"}, "4": {"Down", "char:j"}}, DeadZone: 0.2},
+		},
+	}
+	m, err := buildKeyMap(cfg, hash, map[byte][]string{0xC: {"Enter"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.keys[glfw.Key1]; ok {
+		t.Error("1 is still bound after the ROM bindings replaced key 1")
+	}
+	if _, ok := m.keys[glfw.KeyQ]; ok {
+		t.Error("Q is still bound after the ROM bindings replaced key 4")
+	}
+	for key, want := range map[glfw.Key]byte{glfw.KeyUp: 0x1, glfw.KeyDown: 0x4, glfw.KeyEnter: 0xC, glfw.KeyW: 0x5} {
+		if symbol, ok := m.keys[key]; !ok || symbol != want {
+			t.Errorf("key %d is bound to %X (%v), want %X", key, symbol, ok, want)
+		}
+	}
+	if symbol, ok := m.lookupChar('J'); !ok || symbol != 0x4 {
+		t.Errorf("char J is bound to %X (%v), want 4", symbol, ok)
+	}
+	if m.deadZone != 0.2 {
+		t.Errorf("dead zone %v, want the one of the ROM", m.deadZone)
+	}
+
+	other, err := buildKeyMap(cfg, "0000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if symbol := other.keys[glfw.Key1]; symbol != 0x1 {
+		t.Errorf("the bindings of PONG are used for another ROM")
+	}
+}
+
+func TestBuildKeyMapErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  keyConfig
+		want string
+	}{
+		{"bad CHIP-8 key", keyConfig{Default: map[string][]string{"G": {"Up"}}}, `"G" is not a CHIP-8 key`},
+		{"unknown key", keyConfig{Default: map[string][]string{"1": {"Nope"}}}, `unknown key "Nope"`},
+		{"bad scancode", keyConfig{Default: map[string][]string{"1": {"scancode:x"}}}, "invalid scancode"},
+		{"bad button", keyConfig{Default: map[string][]string{"1": {"button:-1"}}}, "invalid button"},
+		{"dead zone", keyConfig{DeadZone: 1.5}, "dead zone must be between 0 and 1"},
+	}
+	for _, tt := range tests {
+		_, err := buildKeyMap(tt.cfg, "", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestKeyMapApplyUnbinds(t *testing.T) {
+	m := newKeyMap()
+	if err := m.apply(map[string][]string{"a": {"Z", "button:2", "axis:0+"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.apply(map[string][]string{"A": {"X"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.keys[glfw.KeyZ]; ok {
+		t.Error("Z is still bound to A")
+	}
+	if len(m.buttons) != 0 || len(m.axes) != 0 {
+		t.Errorf("controller bindings of A left: %v %v", m.buttons, m.axes)
+	}
+	if symbol := m.keys[glfw.KeyX]; symbol != 0xA {
+		t.Errorf("X is bound to %X, want A", symbol)
+	}
+}
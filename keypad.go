@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+On-screen keypad.
+
+Draws the 4x4 hex keypad of the COSMAC VIP under the screen (-keypad, F2
+toggles it). Held keys are lit, keys the ROM checked recently with EX9E,
+EXA1 or FX0A are tinted, and clicking a key presses it. Touchscreens work
+too, the system turns touches into mouse clicks for GLFW.
+*/
+
+const (
+	keypadScreenShare  = 60 // Percent of the window height used by the screen
+	keypadPolledFrames = 30 // Frames a key stays tinted after the ROM checks it
+)
+
+// Keys in the order of the VIP keypad, row by row
+var keypadLayout = [4][4]byte{
+	{0x1, 0x2, 0x3, 0xC},
+	{0x4, 0x5, 0x6, 0xD},
+	{0x7, 0x8, 0x9, 0xE},
+	{0xA, 0x0, 0xB, 0xF},
+}
+
+var (
+	keypadKeyPalette     = palette{foreground: color.RGBA{0x40, 0x40, 0x40, 0xFF}}
+	keypadPolledPalette  = palette{foreground: color.RGBA{0x30, 0x50, 0x90, 0xFF}}
+	keypadPressedPalette = palette{foreground: color.RGBA{0xE0, 0xB0, 0x30, 0xFF}}
+)
+
+// keypadView is the state of the on-screen keypad.
+type keypadView struct {
+	keys    [16]byte // Keypad state of the last frame
+	polled  [16]int  // Frames left to show each key as checked by the ROM
+	clicked int      // Key held down with the mouse, -1 for none
+}
+
+func newKeypadView() *keypadView {
+	return &keypadView{clicked: -1}
+}
+
+// update takes the state of the cpu after a frame.
+func (k *keypadView) update(keys [16]byte, polled [16]bool) {
+	k.keys = keys
+	for i := range k.polled {
+		if polled[i] {
+			k.polled[i] = keypadPolledFrames
+		} else if k.polled[i] > 0 {
+			k.polled[i]--
+		}
+	}
+}
+
+// keypadCell returns the area of a key in framebuffer pixels, from the top
+// left corner of the framebuffer.
+func keypadCell(ws *windowState, row, col int) (x, y, size int) {
+	top := ws.fbHeight * keypadScreenShare / 100
+	space := min(ws.fbWidth, ws.fbHeight-top)
+	cell := space / 4
+	x = (ws.fbWidth-cell*4)/2 + col*cell
+	y = top + (ws.fbHeight-top-cell*4)/2 + row*cell
+	return x, y, cell
+}
+
+// keyAt returns the key under a point of the framebuffer.
+func keyAt(ws *windowState, px, py int) (byte, bool) {
+	for row := range 4 {
+		for col := range 4 {
+			x, y, size := keypadCell(ws, row, col)
+			if px >= x && px < x+size && py >= y && py < y+size {
+				return keypadLayout[row][col], true
+			}
+		}
+	}
+	return 0, false
+}
+
+func drawKeypad(ws *windowState, program uint32, k *keypadView) {
+	var cells, polled, pressed, labels textBatch
+	for _, b := range []*textBatch{&cells, &polled, &pressed, &labels} {
+		b.fbWidth, b.fbHeight = ws.fbWidth, ws.fbHeight
+	}
+
+	for row := range 4 {
+		for col := range 4 {
+			key := keypadLayout[row][col]
+			x, y, size := keypadCell(ws, row, col)
+			gap := max(1, size/16)
+			switch {
+			case k.keys[key] != 0:
+				pressed.addRect(x+gap, y+gap, size-2*gap, size-2*gap)
+			case k.polled[key] > 0:
+				polled.addRect(x+gap, y+gap, size-2*gap, size-2*gap)
+			default:
+				cells.addRect(x+gap, y+gap, size-2*gap, size-2*gap)
+			}
+			scale := max(1, size/(3*glyphHeight))
+			labels.add(x+(size-glyphWidth*scale)/2, y+(size-glyphHeight*scale)/2, scale, fmt.Sprintf("%X", key))
+		}
+	}
+
+	gl.Viewport(0, 0, int32(ws.fbWidth), int32(ws.fbHeight))
+	cells.draw(program, keypadKeyPalette)
+	polled.draw(program, keypadPolledPalette)
+	pressed.draw(program, keypadPressedPalette)
+	labels.draw(program, osdTextPalette)
+	ws.applyViewport()
+}
+
+// mouseHandler presses the keys clicked on the keypad.
+func mouseHandler(b *glfwBackend) {
+	b.ws.window.SetMouseButtonCallback(func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		if button != glfw.MouseButtonLeft {
+			return
+		}
+		switch {
+		case action == glfw.Press && b.ws.keypad:
+			// The cursor is in window coordinates, the keypad in framebuffer pixels
+			cx, cy := w.GetCursorPos()
+			width, height := w.GetSize()
+			if width == 0 || height == 0 {
+				return
+			}
+			px := int(cx * float64(b.ws.fbWidth) / float64(width))
+			py := int(cy * float64(b.ws.fbHeight) / float64(height))
+			if key, ok := keyAt(b.ws, px, py); ok {
+				b.keypad.clicked = int(key)
+				b.keys.push(key, true)
+			}
+		case action == glfw.Release && b.keypad.clicked >= 0:
+			b.keys.push(byte(b.keypad.clicked), false)
+			b.keypad.clicked = -1
+		}
+	})
+}
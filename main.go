@@ -13,96 +13,596 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-
-	"github.com/go-gl/glfw/v3.2/glfw"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-var KEY_MAP = map[glfw.Key]byte{
-	glfw.Key1: 0x1,
-	glfw.Key2: 0x2,
-	glfw.Key3: 0x3,
-	glfw.Key4: 0xC,
-	glfw.KeyQ: 0x4,
-	glfw.KeyW: 0x5,
-	glfw.KeyE: 0x6,
-	glfw.KeyR: 0xD,
-	glfw.KeyA: 0x7,
-	glfw.KeyS: 0x8,
-	glfw.KeyD: 0x9,
-	glfw.KeyF: 0xE,
-	glfw.KeyZ: 0xA,
-	glfw.KeyX: 0x0,
-	glfw.KeyC: 0xB,
-	glfw.KeyV: 0xF,
-}
+// The screen is presented at 60 Hz, like the CHIP-8 timers.
+const frameDuration = time.Second / 60
+
+// Print the cpu state after every instruction (-trace)
+var traceInstructions bool
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "info" {
+		os.Exit(runInfo(os.Args[2:]))
+	}
+
+	persistence := flag.String("persistence", "off", "flicker reduction: off, phosphor or blend")
+	decay := flag.Float64("decay", 0.25, "brightness lost per frame by a pixel in phosphor mode (0-1]")
+	keysPath := flag.String("keys", "keys.json", "JSON file with the key bindings")
+	postConfigPath := flag.String("postprocess", "postprocess.json", "JSON file with the chain of post-processing shaders")
+	scale := flag.Int("scale", 10, "window and screenshot size as a multiple of 64x32")
+	scaling := flag.String("scaling", "integer", "how the screen fills the window: integer or aspect")
+	fullscreen := flag.Bool("fullscreen", false, "start in fullscreen")
+	monitor := flag.Int("monitor", 0, "monitor used for fullscreen")
+	paletteFlag := flag.String("palette", "000000,FFFFFF", "background and foreground colors in hex")
+	ipf := flag.Int("ipf", 10, "instructions executed per frame (60 frames per second)")
+	frontend := flag.String("frontend", "window", "where the game is played: window or terminal")
+	headless := flag.Bool("headless", false, "run without a window, see -frames and -screenshot-at-frame")
+	frames := flag.Int("frames", 0, "number of frames to run in headless mode")
+	screenshotFrame := flag.Int("screenshot-at-frame", 0, "save a PNG of frame N to the path given as argument (default screenshot.png)")
+	record := flag.String("record", "", "record the game from the start to this GIF file (F9 toggles recording)")
+	audioMode := flag.String("audio", "auto", "buzzer output: auto, player, console or none")
+	wavPath := flag.String("wav", "", "also write the buzzer to this WAV file")
+	waveform := flag.String("waveform", "square", "buzzer waveform: square, triangle, sawtooth or sine")
+	toneFreq := flag.Float64("tone", 440, "buzzer frequency in Hz")
+	volume := flag.Float64("volume", 0.25, "buzzer volume (0-1)")
+	osd := flag.Bool("osd", true, "show FPS, speed and ROM name in the window (F3 toggles it)")
+	keypad := flag.Bool("keypad", false, "show a clickable hex keypad under the screen (F2 toggles it)")
+	watch := flag.Bool("watch", false, "reload the ROM when the file changes")
+	watchKeep := flag.Bool("watch-keep", false, "keep the key bindings, held keys and speed when the ROM is reloaded")
+	fastForward := flag.Int("fast-forward", 4, "speed multiplier while the fast forward hotkey is held")
+	slowMotion := flag.Float64("slow-motion", 0.25, "speed of slow motion, as a fraction of the normal speed")
+	romFlag := flag.String("rom", "", "ROM to load (default PONG in -romdir, or the menu)")
+	patchFlag := flag.String("patch", "", "IPS or BPS patch applied to the ROM of -rom (default ROM.ips or ROM.bps next to it)")
+	romDir := flag.String("romdir", "games", "directory listed in the ROM menu (F1)")
+	platform := flag.String("platform", "", "interpreter to behave like: vip, schip or a platform of the ROM database (default from the ROM)")
+	romDBPath := flag.String("romdb", "", "directory with the files of the CHIP-8 database, replacing the embedded copy")
+	inputRecord := flag.String("input-record", "", "save the keypad state of every frame to this file")
+	inputReplay := flag.String("input-replay", "", "play back the keys saved with -input-record")
+	seed := flag.Int64("seed", 0, "seed of the random numbers (CXNN), 0 picks one")
+	save := flag.Bool("save", true, "save -scale, -palette, -volume, -romdir, and -platform and -ipf for the ROM, in the config file for the next runs")
+	configPath := flag.String("config", defaultConfigPath(), "JSON file with the saved settings, empty to use none")
+	flag.BoolVar(&traceInstructions, "trace", false, "print the cpu state after every instruction")
+	flag.Parse()
+
+	// Flags given on the command line win over the config file and the ROM
+	// database
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	userCfg, err := loadUserConfig(*configPath)
+	if err == nil {
+		err = userCfg.applySettings(explicit)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	userCfg.readOnly = !*save || *headless
+	filter, err := newScreenFilter(*persistence, *decay)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	mode, ok := scalingModes[*scaling]
+	if !ok {
+		fmt.Printf("unknown scaling mode %q (use integer or aspect)\n", *scaling)
+		os.Exit(2)
+	}
+	if *scale < 1 || *ipf < 1 {
+		fmt.Println("scale and ipf must be at least 1")
+		os.Exit(2)
+	}
+	pal, err := parsePalette(*paletteFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	tone, err := newToneGenerator(*waveform, *toneFreq, *volume)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if *headless && (*audioMode == "auto" || *audioMode == "player") {
+		// Don't play sound on servers and CI, -wav still works
+		*audioMode = "none"
+	}
+	audio, err := newAudio(*audioMode, *wavPath, tone)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	renderer := softRenderer{scale: *scale, palette: pal}
+	screenshotPath := "screenshot.png"
+	if flag.NArg() > 0 {
+		screenshotPath = flag.Arg(0)
+	}
+	recorder := &gifRecorder{renderer: renderer, path: *record}
+	if *record != "" {
+		recorder.start()
+	}
+
+	var replay *replayInput
+	if *inputReplay != "" {
+		replay, err = loadInputReplay(*inputReplay)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		// The replay only matches the recording with the same seed, the
+		// speed is in the events
+		*seed = replay.seed
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
 
-	window := initWindowEmulator()
-	defer glfw.Terminate()
-	program := initOpenGL()
+	romDB, err := loadROMDatabase(*romDBPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	keyCfg, err := loadKeyConfig(*keysPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	keyCfg = userCfg.mergeKeys(keyCfg)
+	hotkeys, err := buildHotkeys(keyCfg.Hotkeys)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if *fastForward < 1 || *slowMotion <= 0 || *slowMotion > 1 {
+		fmt.Println("fast-forward must be at least 1 and slow-motion between 0 and 1")
+		os.Exit(2)
+	}
+	loader := &romLoader{db: romDB, keyCfg: keyCfg, config: userCfg, explicit: explicit, defaultIPF: *ipf, defaultPalette: pal}
+	chosen := userCfg.chosen(explicit)
+	if chosen["platform"] && *platform != "" {
+		if _, err := romDB.platformByName(*platform); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		loader.platform = *platform
+	}
+	if chosen["ipf"] {
+		loader.ipf = *ipf
+	}
+	if chosen["palette"] {
+		loader.palette = &pal
+	}
+	// Saved only once they are known to work, a bad value would stop every
+	// later run
+	if err := userCfg.rememberFlags(explicit); err != nil {
+		fmt.Println("saving the config:", err)
+	}
 
 	// Initialize the CPU
-	cpu := cpu{}
+	cpu := cpu{rng: rand.New(rand.NewSource(*seed))}
+
+	// Initialize the Chip8 system and load the game into the memory. Without
+	// -rom the window and the terminal start in the menu when there is no PONG.
+	romPath := *romFlag
+	if romPath == "" {
+		romPath = filepath.Join(*romDir, "PONG")
+	}
+	if *patchFlag != "" {
+		if *romFlag == "" {
+			fmt.Println("-patch needs the ROM to patch given with -rom")
+			os.Exit(2)
+		}
+		loader.patches = map[string]string{romPath: *patchFlag}
+	}
+	var rom *romSetup
+	if _, err := os.Stat(romPath); *romFlag != "" || err == nil || *headless {
+		rom, err = loader.load(&cpu, romPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		var romSettings romUserConfig
+		if explicit["platform"] {
+			romSettings.Platform = *platform
+		}
+		if explicit["ipf"] {
+			romSettings.IPF = *ipf
+		}
+		if err := userCfg.rememberROM(rom, romSettings); err != nil {
+			fmt.Println("saving the config:", err)
+		}
+	}
+
+	// The backends start with the settings of the ROM, or the defaults when
+	// the menu comes first
+	var keymap *keyMap
+	startPalette := pal
+	if rom != nil {
+		keymap, startPalette = rom.keymap, rom.palette
+	} else if keymap, err = buildKeyMap(keyCfg, "", nil); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
 
-	// Initialize the Chip8 system and load the game into the memory
-	cpu.initEmulator()
-	cpu.loadGame("games/PONG")
+	emu := &emulator{
+		cpu:             &cpu,
+		loader:          loader,
+		config:          userCfg,
+		watch:           *watch,
+		watchKeep:       *watchKeep,
+		speed:           emulationSpeed{fastForwardRate: *fastForward, slowMotionRate: *slowMotion},
+		ipf:             *ipf,
+		realtime:        true,
+		screenshotFrame: *screenshotFrame,
+		screenshotPath:  screenshotPath,
+		renderer:        renderer,
+		recorder:        recorder,
+		audio:           audio,
+	}
+
+	// Before the frontends, an error here leaves the terminal as it was
+	if *inputRecord != "" {
+		r, err := newInputRecorder(*inputRecord, *seed)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		defer r.close()
+		emu.inputRecord = r
+	}
 
-	keyboardHandler(window, &cpu)
+	switch {
+	case *headless:
+		emu.display = headlessDisplay{}
+		emu.input = nullInput{}
+		emu.realtime = false
+		emu.maxFrames = *frames
+		if emu.maxFrames == 0 {
+			emu.maxFrames = *screenshotFrame
+		}
+		if emu.maxFrames <= 0 {
+			fmt.Println("headless mode needs -frames or -screenshot-at-frame")
+			os.Exit(2)
+		}
+	case *frontend == "terminal":
+		t, err := newTerminalFrontend(startPalette, keymap, &romMenu{loader: loader, dir: *romDir})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		emu.display, emu.input = t, t
+	case *frontend == "window":
+		b, err := newGLFWBackend(glfwOptions{
+			scale:      *scale,
+			scaling:    mode,
+			fullscreen: *fullscreen,
+			monitor:    *monitor,
+			postConfig: *postConfigPath,
+			filter:     filter,
+			palette:    startPalette,
+			renderer:   renderer,
+			recorder:   recorder,
+			osd:        *osd,
+			keypad:     *keypad,
+			keymap:     keymap,
+			menu:       &romMenu{loader: loader, dir: *romDir},
+			hotkeys:    hotkeys,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		emu.display, emu.input = b, b
+	default:
+		fmt.Printf("unknown frontend %q (use window or terminal)\n", *frontend)
+		os.Exit(2)
+	}
 
-	emulationLoop(&cpu, window, program)
+	if replay != nil {
+		// The keys come from the replay, the hotkeys still work
+		emu.input, emu.replay = nullInput{}, replay
+	}
 
+	if rom != nil {
+		emu.applyROM(rom)
+	} else if m, ok := emu.display.(romMenuDisplay); ok {
+		m.OpenMenu()
+	}
+	emu.run()
 }
 
-func emulationLoop(cpu *cpu, window *glfw.Window, program uint32) {
-	for !window.ShouldClose() {
-		// Main emulation loop
-		// Emulate one cycle
+// emulator drives the cpu frame by frame and connects it to the backends.
+type emulator struct {
+	cpu     *cpu
+	display Display
+	input   Input
+	audio   Audio
+
+	ipf       int  // Instructions executed per frame
+	realtime  bool // Wait for the next frame, false runs as fast as possible
+	maxFrames int  // Stop after this many frames, 0 runs until the display is closed
+	frame     int  // Frames emulated so far
+
+	loader     *romLoader
+	config     *userConfig
+	rom        *romSetup // Nil until a ROM is loaded
+	watch      bool      // Reload the ROM when the file changes
+	watchKeep  bool      // Keep key bindings, keys and speed when reloading
+	watcher    *romWatcher
+	speed      emulationSpeed
+	statsStart time.Time // Start of the current FPS measurement
+	statsFrame int       // Frame number at statsStart
+	status     emulatorStatus
+
+	inputRecord *inputRecorder // -input-record, nil when not recording
+	replay      *replayInput   // -input-replay
+
+	screenshotFrame int // Frame saved to screenshotPath, 0 for none
+	screenshotPath  string
+	renderer        softRenderer
+	recorder        *gifRecorder
+}
+
+// runFrame emulates one frame: a batch of instructions and one tick of the
+// timers, which count down at 60 Hz.
+func runFrame(cpu *cpu, ipf int) {
+	cpu.polled = [16]bool{}
+	cpu.vblankWait = false
+	for i := 0; i < ipf; i++ {
 		cpu.emulateCycle()
-		cpu.codeDebugger()
+		if traceInstructions {
+			cpu.codeDebugger()
+		}
+	}
+
+	// Handle timers
+	cpu.handleTimers()
+}
+
+func (e *emulator) run() {
+	defer e.display.Close()
+	defer e.audio.Close()
+	defer e.recorder.stop()
+
+	nextFrame := time.Now()
+	e.statsStart = nextFrame
+	for !e.display.ShouldClose() {
+		// Main emulation loop
+		e.input.Update(&e.cpu.key)
+		e.handleControls()
+		if e.watcher != nil && e.replay == nil && e.watcher.changed(time.Now()) {
+			e.reloadROM(e.watchKeep, "Reloaded")
+		}
+		menuOpen := false
+		if m, ok := e.display.(romMenuDisplay); ok {
+			if path, ok := m.ChosenROM(); ok && e.replay != nil {
+				notify("Can't load ROMs during a replay")
+			} else if ok {
+				e.loadROM(path)
+			}
+			menuOpen = m.MenuOpen() || e.rom == nil
+		}
+
+		frames := e.speed.frames()
+		if menuOpen {
+			frames = 0
+		}
+		first := e.frame
+		for i := 0; i < frames; i++ {
+			e.frameInput()
+			runFrame(e.cpu, e.ipf)
+			e.recorder.capture(&e.cpu.gfx)
+			e.frame++
+		}
+		// Silent while paused, and in fast forward the samples would pile up
+		e.audio.Update(frames == 1 && e.cpu.sound_timer > 0)
 
-		if cpu.drawFlag {
-			drawSpriteOnWindow(window, program, cpu)
-			//cpu.debugRender()
-			cpu.drawFlag = false
+		e.updateStatus()
+		if d, ok := e.display.(keypadDisplay); ok {
+			d.SetKeypad(e.cpu.key, e.cpu.polled)
 		}
+		e.display.Present(&e.cpu.gfx)
+		//cpu.debugRender()
+		e.cpu.drawFlag = false
 
-		// Handle timers
-		cpu.handleTimers()
+		if first < e.screenshotFrame && e.frame >= e.screenshotFrame {
+			if err := e.renderer.savePNG(&e.cpu.gfx, e.screenshotPath); err != nil {
+				fmt.Printf("Error saving screenshot: %v\n", err)
+			} else {
+				fmt.Printf("Screenshot of frame %d saved to %s\n", e.frame, e.screenshotPath)
+			}
+		}
+		if e.maxFrames > 0 && e.frame >= e.maxFrames {
+			return
+		}
 
-		glfw.PollEvents()
+		if !e.realtime {
+			continue
+		}
+		// Wait for the next frame, if we fell behind don't try to catch up
+		delay := frameDuration
+		if e.speed.slowMotion && !e.speed.paused {
+			delay = time.Duration(float64(frameDuration) / e.speed.slowMotionRate)
+		}
+		nextFrame = nextFrame.Add(delay)
+		if wait := time.Until(nextFrame); wait > 0 {
+			time.Sleep(wait)
+		} else {
+			nextFrame = time.Now()
+		}
+	}
+}
 
+// frameInput replays or records the input of the emulated frame about to
+// run.
+func (e *emulator) frameInput() {
+	if e.replay != nil {
+		for _, ev := range e.replay.frame(e.frame, &e.cpu.key) {
+			switch ev.action {
+			case "reset":
+				e.reloadROM(true, "Reset")
+			case "reload":
+				e.reloadROM(false, "Reloaded")
+			case "load":
+				e.loadROM(ev.path)
+			case "ipf":
+				e.setIPF(ev.ipf)
+			}
+		}
+		// A reset keeps the keys of before, the replay has the right ones
+		e.replay.frame(e.frame, &e.cpu.key)
+	}
+	if e.inputRecord != nil {
+		e.inputRecord.keys(e.frame, &e.cpu.key)
 	}
 }
 
-func on_keyboard_pressed(c *cpu, key glfw.Key, action glfw.Action) {
-	if key == glfw.KeyEscape && action == glfw.Press {
-		glfw.Terminate()
-		return
+// recordEvent saves a change of ROM or speed in the input recording.
+func (e *emulator) recordEvent(format string, args ...any) {
+	if e.inputRecord != nil {
+		e.inputRecord.event(e.frame, format, args...)
 	}
+}
+
+// setIPF changes the instructions per frame.
+func (e *emulator) setIPF(ipf int) {
+	e.recordEvent("ipf %d", ipf)
+	e.ipf = ipf
+}
 
-	if symbol, ok := KEY_MAP[key]; ok {
-		c.key[symbol] = 1
-		fmt.Printf("Key pressed: %v, Action: %v\n", key, action)
+// loadROM switches to another game, resetting the cpu.
+func (e *emulator) loadROM(path string) {
+	rom, err := e.loader.load(e.cpu, path)
+	if err != nil {
+		notify("%v", err)
+		return
+	}
+	e.recordEvent("load %s", path)
+	e.applyROM(rom)
+	notify("Loaded %s", rom.name)
+	// The menu opens there the next time, from any directory
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err == nil {
+		err = e.config.remember(map[string]any{"romdir": dir})
+	}
+	if err != nil {
+		fmt.Println("saving the config:", err)
 	}
 }
 
-func on_keyboard_released(c *cpu, key glfw.Key, action glfw.Action) {
-	if symbol, ok := KEY_MAP[key]; ok {
-		c.key[symbol] = 0
-		fmt.Printf("Key released: %v, Action: %v\n", key, action)
+// reloadROM loads again the current ROM, after it changed on disk or to
+// reset the game. With keep the key bindings, held keys and speed stay.
+func (e *emulator) reloadROM(keep bool, message string) {
+	if e.rom == nil {
+		return
+	}
+	previous := e.rom
+	keys := e.cpu.key
+	rom, err := e.loader.load(e.cpu, previous.path)
+	if err != nil {
+		notify("%v", err)
+		return
 	}
+	if keep {
+		rom.keymap, rom.ipf = previous.keymap, e.ipf
+		e.cpu.key = keys
+		e.recordEvent("reset")
+	} else {
+		e.recordEvent("reload")
+	}
+	e.applyROM(rom)
+	notify("%s %s", message, rom.name)
 }
 
-func keyboardHandler(window *glfw.Window, c *cpu) {
-	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
-		switch action {
-		case glfw.Press:
-			on_keyboard_pressed(c, key, action)
-		case glfw.Release:
-			on_keyboard_released(c, key, action)
+// handleControls applies the hotkeys pressed since the last frame.
+func (e *emulator) handleControls() {
+	// The display, the input may be wrapped by a recorder
+	c, ok := e.display.(controlInput)
+	if !ok {
+		return
+	}
+	pressed, fastForward := c.Controls()
+	e.speed.fastForward = fastForward
+	for _, h := range pressed {
+		switch h {
+		case hotkeyPause:
+			e.speed.paused = !e.speed.paused
+		case hotkeyReset:
+			if e.replay != nil {
+				notify("Can't reset during a replay")
+				break
+			}
+			e.reloadROM(true, "Reset")
+		case hotkeyFrameAdvance:
+			e.speed.paused, e.speed.advance = true, true
+		case hotkeySlowMotion:
+			e.speed.slowMotion = !e.speed.slowMotion
+		case hotkeyCartridge:
+			e.exportCartridge()
 		}
-	})
+	}
+}
+
+// exportCartridge saves the ROM with the quirks, speed and colors in use as
+// an Octo cartridge in the current directory.
+func (e *emulator) exportCartridge() {
+	if e.rom == nil {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(e.rom.path), filepath.Ext(e.rom.path))
+	path := name + ".gif"
+	if filepath.Ext(e.rom.path) == ".gif" {
+		path = name + "-export.gif"
+	}
+	options := cartridgeOptionsOf(e.rom.profile.platform.ID, e.cpu.quirks, e.ipf, e.renderer.palette)
+	err := writeCartridge(path, e.rom.name, e.rom.data, options, e.renderer.palette)
+	if errors.Is(err, fs.ErrExist) {
+		notify("Cartridge not saved: %s already exists", path)
+		return
+	}
+	if err != nil {
+		notify("Error saving cartridge: %v", err)
+		return
+	}
+	notify("Cartridge saved to %s", path)
+}
+
+// applyROM uses the settings of a ROM just loaded in the cpu.
+func (e *emulator) applyROM(rom *romSetup) {
+	if e.watch && (e.rom == nil || e.rom.path != rom.path) {
+		e.watcher = newROMWatcher(romFile(rom.path))
+	}
+	e.rom = rom
+	e.setIPF(rom.ipf)
+	e.status.rom = rom.name
+	e.renderer.palette = rom.palette
+	e.recorder.renderer.palette = rom.palette
+	if d, ok := e.display.(romDisplay); ok {
+		d.SetROM(rom)
+	}
+}
+
+// updateStatus measures the speed once per second and sends it to the
+// display, when it can show it.
+func (e *emulator) updateStatus() {
+	if elapsed := time.Since(e.statsStart); elapsed >= time.Second {
+		frames := float64(e.frame - e.statsFrame)
+		e.status.fps = frames / elapsed.Seconds()
+		e.status.ips = frames * float64(e.ipf) / elapsed.Seconds()
+		e.statsStart = time.Now()
+		e.statsFrame = e.frame
+	}
+	e.status.state = e.speed.state()
+	if d, ok := e.display.(statusDisplay); ok {
+		d.SetStatus(e.status)
+	}
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+/*
+On-screen display.
+
+Shows the emulator status (FPS, instructions per second, ROM name and state)
+in the corner of the window, and transient messages such as saved
+screenshots or unknown opcodes at the bottom. Messages are also printed on
+stdout, so backends without an OSD still show them somewhere.
+*/
+
+const (
+	osdMessageDuration = 3 * time.Second
+	osdMaxMessages     = 4
+)
+
+type osdMessage struct {
+	text    string
+	expires time.Time
+}
+
+var osdMessages struct {
+	sync.Mutex
+	list []osdMessage
+}
+
+// notify shows a transient message. The same message repeated while it is
+// still on screen only extends its time, a ROM stuck on an unknown opcode
+// would flood the output otherwise.
+func notify(format string, args ...any) {
+	text := fmt.Sprintf(format, args...)
+	expires := time.Now().Add(osdMessageDuration)
+
+	osdMessages.Lock()
+	defer osdMessages.Unlock()
+	if n := len(osdMessages.list); n > 0 && osdMessages.list[n-1].text == text && time.Now().Before(osdMessages.list[n-1].expires) {
+		osdMessages.list[n-1].expires = expires
+		return
+	}
+	fmt.Println(text)
+	osdMessages.list = append(osdMessages.list, osdMessage{text: text, expires: expires})
+	if len(osdMessages.list) > osdMaxMessages {
+		osdMessages.list = osdMessages.list[1:]
+	}
+}
+
+// activeMessages returns the messages that haven't expired yet, oldest first.
+func activeMessages(now time.Time) []string {
+	osdMessages.Lock()
+	defer osdMessages.Unlock()
+	var texts []string
+	kept := osdMessages.list[:0]
+	for _, m := range osdMessages.list {
+		if now.Before(m.expires) {
+			kept = append(kept, m)
+			texts = append(texts, m.text)
+		}
+	}
+	osdMessages.list = kept
+	return texts
+}
+
+type emulatorStatus struct {
+	fps   float64
+	ips   float64 // Instructions per second
+	rom   string
+	state string // Empty while running at normal speed
+}
+
+// statusDisplay is implemented by the displays that can show the status.
+type statusDisplay interface {
+	SetStatus(s emulatorStatus)
+}
+
+// keypadDisplay is implemented by the displays that show the keypad, they
+// get the keys held and the keys the ROM checked in the last frame.
+type keypadDisplay interface {
+	SetKeypad(keys [16]byte, polled [16]bool)
+}
+
+func (s emulatorStatus) lines() []string {
+	lines := []string{
+		s.rom,
+		fmt.Sprintf("%.0f FPS  %.0f IPS", s.fps, s.ips),
+	}
+	if s.state != "" {
+		lines = append(lines, s.state)
+	}
+	return lines
+}
+
+// textBatch collects the pixels of a block of text as triangles, so the
+// whole OSD is sent to OpenGL in one draw call.
+type textBatch struct {
+	vertices []float32
+	fbWidth  int
+	fbHeight int
+}
+
+// add writes text with its top left corner at (x, y) framebuffer pixels.
+func (t *textBatch) add(x, y, scale int, text string) {
+	for i, ch := range strings.ToUpper(text) {
+		glyph, ok := osdFont[ch]
+		if !ok {
+			glyph = osdFont['?']
+		}
+		gx := x + i*(glyphWidth+1)*scale
+		for row := 0; row < glyphHeight; row++ {
+			for col := 0; col < glyphWidth; col++ {
+				if glyph[row]&(0x10>>col) != 0 {
+					t.addPixel(gx+col*scale, y+row*scale, scale)
+				}
+			}
+		}
+	}
+}
+
+func (t *textBatch) addPixel(x, y, size int) {
+	t.addRect(x, y, size, size)
+}
+
+// addRect adds a filled rectangle with its top left corner at (x, y).
+func (t *textBatch) addRect(x, y, width, height int) {
+	// Framebuffer pixels to OpenGL coordinates, Y goes up in OpenGL
+	startX := -1 + 2*float32(x)/float32(t.fbWidth)
+	endX := -1 + 2*float32(x+width)/float32(t.fbWidth)
+	startY := 1 - 2*float32(y)/float32(t.fbHeight)
+	endY := 1 - 2*float32(y+height)/float32(t.fbHeight)
+
+	t.vertices = append(t.vertices,
+		startX, startY, 0,
+		startX, endY, 0,
+		endX, endY, 0,
+
+		startX, startY, 0,
+		endX, startY, 0,
+		endX, endY, 0,
+	)
+}
+
+func (t *textBatch) draw(program uint32, pal palette) {
+	if len(t.vertices) == 0 {
+		return
+	}
+	gl.UseProgram(program)
+	setPalette(program, pal)
+	setIntensity(program, 1)
+	drawVertices(t.vertices)
+}
+
+var (
+	osdTextPalette   = palette{foreground: defaultPalette.foreground}
+	osdShadowPalette = palette{foreground: defaultPalette.background}
+)
+
+// drawOSD draws the status and the messages over the whole window.
+func drawOSD(ws *windowState, program uint32, status emulatorStatus, showStatus bool) {
+	lines := activeMessages(time.Now())
+	if !showStatus && len(lines) == 0 {
+		return
+	}
+
+	scale := max(1, ws.fbHeight/240)
+	lineHeight := (glyphHeight + 2) * scale
+	margin := 2 * scale
+
+	var text, shadow textBatch
+	for _, b := range []*textBatch{&text, &shadow} {
+		b.fbWidth, b.fbHeight = ws.fbWidth, ws.fbHeight
+	}
+	addLine := func(x, y int, s string) {
+		shadow.add(x+scale, y+scale, scale, s)
+		text.add(x, y, scale, s)
+	}
+
+	if showStatus {
+		for i, line := range status.lines() {
+			addLine(margin, margin+i*lineHeight, line)
+		}
+	}
+	for i, line := range lines {
+		y := ws.fbHeight - margin - (len(lines)-i)*lineHeight
+		addLine(margin, y, line)
+	}
+
+	gl.Viewport(0, 0, int32(ws.fbWidth), int32(ws.fbHeight))
+	shadow.draw(program, osdShadowPalette)
+	text.draw(program, osdTextPalette)
+	ws.applyViewport()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ROM patches.
+
+IPS and BPS patches (bug fixes, translations) are applied in memory when the
+ROM is loaded, the file of the ROM is not changed. A patch is found next to
+the ROM with the same name and the extension .ips or .bps (PONG.ips or
+pong.ch8.bps for pong.ch8), or given with -patch for the ROM of -rom.
+
+BPS patches have the CRC32 of the ROM they were made for, of the result and
+of the patch itself, a patch for another ROM or version is refused. IPS
+patches have no checksums and are applied to any ROM.
+
+The database, the config file and the key bindings still use the SHA-1 of
+the ROM before the patch, a patched game is the same game.
+*/
+
+var patchExtensions = []string{".bps", ".ips"}
+
+// findPatch returns the patch next to the ROM at path, empty if there is
+// none. ROMs inside archives have no patches next to them.
+func findPatch(path string) string {
+	if _, _, ok := splitArchivePath(path); ok {
+		return ""
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, name := range []string{base, path} {
+		for _, ext := range patchExtensions {
+			if _, err := os.Stat(name + ext); err == nil {
+				return name + ext
+			}
+		}
+	}
+	return ""
+}
+
+// applyPatch returns the ROM changed by the patch file at path.
+func applyPatch(rom []byte, path string) ([]byte, error) {
+	patch, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patched []byte
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		patched, err = applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		patched, err = applyBPS(rom, patch)
+	default:
+		err = errors.New("not an IPS or BPS patch")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("patch %s: %v", filepath.Base(path), err)
+	}
+	return patched, nil
+}
+
+var errPatchTruncated = errors.New("the patch is truncated")
+
+// applyIPS applies an IPS patch: records of a 3 byte offset, a 2 byte size
+// and the data, or a size of 0 followed by a run length and one byte.
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	out := append([]byte{}, rom...)
+	p := patch[len("PATCH"):]
+	for {
+		if len(p) < 3 {
+			return nil, errPatchTruncated
+		}
+		if string(p[:3]) == "EOF" {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, errPatchTruncated
+		}
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(binary.BigEndian.Uint16(p[3:5]))
+		p = p[5:]
+
+		var data []byte
+		if size > 0 {
+			if len(p) < size {
+				return nil, errPatchTruncated
+			}
+			data, p = p[:size], p[size:]
+		} else {
+			if len(p) < 3 {
+				return nil, errPatchTruncated
+			}
+			data = bytes.Repeat(p[2:3], int(binary.BigEndian.Uint16(p[:2])))
+			p = p[3:]
+		}
+		if end := offset + len(data); end > len(out) {
+			if end > maxROMSize {
+				return nil, fmt.Errorf("the patch writes at 0x%X, past the end of memory", offset+0x200)
+			}
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+	// Extension of some patchers: the size of the result after EOF
+	if len(p) >= 3 {
+		if size := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// bpsReader reads the numbers of a BPS patch.
+type bpsReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *bpsReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = errPatchTruncated
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+// number reads a variable length number, 7 bits per byte with the last
+// byte marked by the high bit.
+func (r *bpsReader) number() int {
+	n, shift := 0, 1
+	for r.err == nil {
+		b := r.byte()
+		n += int(b&0x7F) * shift
+		if b&0x80 != 0 || shift > 1<<28 {
+			break
+		}
+		shift <<= 7
+		n += shift
+	}
+	return n
+}
+
+// signed reads a relative offset, the lowest bit is the sign.
+func (r *bpsReader) signed() int {
+	n := r.number()
+	if n&1 != 0 {
+		return -(n >> 1)
+	}
+	return n >> 1
+}
+
+// applyBPS applies a BPS patch after checking that it was made for rom.
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < len("BPS1")+12 {
+		return nil, errPatchTruncated
+	}
+	footer := patch[len(patch)-12:]
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:4])
+	targetCRC := binary.LittleEndian.Uint32(footer[4:8])
+	patchCRC := binary.LittleEndian.Uint32(footer[8:12])
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != patchCRC {
+		return nil, errors.New("the patch is damaged (bad checksum)")
+	}
+	if crc32.ChecksumIEEE(rom) != sourceCRC {
+		return nil, fmt.Errorf("made for another ROM (CRC32 %08X, this ROM is %08X)", sourceCRC, crc32.ChecksumIEEE(rom))
+	}
+
+	r := &bpsReader{data: patch[:len(patch)-12], pos: len("BPS1")}
+	sourceSize := r.number()
+	targetSize := r.number()
+	r.pos += r.number() // Metadata
+	if r.err != nil || r.pos > len(r.data) {
+		return nil, errPatchTruncated
+	}
+	if sourceSize != len(rom) {
+		return nil, fmt.Errorf("made for a ROM of %d bytes, this ROM has %d", sourceSize, len(rom))
+	}
+	if targetSize > maxROMSize {
+		return nil, fmt.Errorf("the patched ROM has %d bytes, the largest ROM that fits in memory is %d bytes", targetSize, maxROMSize)
+	}
+
+	out := make([]byte, 0, targetSize)
+	sourceOffset, targetOffset := 0, 0
+	for r.pos < len(r.data) && r.err == nil {
+		action := r.number()
+		length := action>>2 + 1
+		if len(out)+length > targetSize {
+			return nil, errors.New("the patch writes past the end of the ROM")
+		}
+		switch action & 3 {
+		case 0: // Source read, the same bytes as the source
+			if len(out)+length > len(rom) {
+				return nil, errors.New("the patch reads past the end of the ROM")
+			}
+			out = append(out, rom[len(out):len(out)+length]...)
+		case 1: // Target read, bytes from the patch
+			if r.pos+length > len(r.data) {
+				return nil, errPatchTruncated
+			}
+			out = append(out, r.data[r.pos:r.pos+length]...)
+			r.pos += length
+		case 2: // Source copy, from anywhere in the source
+			sourceOffset += r.signed()
+			if sourceOffset < 0 || sourceOffset+length > len(rom) {
+				return nil, errors.New("the patch reads past the end of the ROM")
+			}
+			out = append(out, rom[sourceOffset:sourceOffset+length]...)
+			sourceOffset += length
+		case 3: // Target copy, from the result so far, byte by byte as it can overlap
+			targetOffset += r.signed()
+			if targetOffset < 0 || targetOffset >= len(out) {
+				return nil, errors.New("the patch copies from outside the ROM")
+			}
+			for i := 0; i < length; i++ {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(out) != targetSize || crc32.ChecksumIEEE(out) != targetCRC {
+		return nil, errors.New("the patched ROM doesn't match the checksum of the patch")
+	}
+	return out, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyIPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4}
+	patch := []byte("PATCH")
+	patch = append(patch, 0, 0, 1, 0, 2, 9, 9)    // 9 9 at 1
+	patch = append(patch, 0, 0, 4, 0, 0, 0, 3, 7) // 7 7 7 at 4, past the end
+	patch = append(patch, "EOF"...)
+	got, err := applyIPS(rom, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 9, 9, 3, 7, 7, 7}; !bytes.Equal(got, want) {
+		t.Errorf("got %X, want %X", got, want)
+	}
+	if !bytes.Equal(rom, []byte{0, 1, 2, 3, 4}) {
+		t.Error("the ROM was changed in place")
+	}
+
+	// Size of the result after EOF
+	got, err = applyIPS(rom, append(bytes.Clone(patch), 0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 9, 9}; !bytes.Equal(got, want) {
+		t.Errorf("truncated to %X, want %X", got, want)
+	}
+}
+
+func TestApplyIPSErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch []byte
+		want  string
+	}{
+		{"no EOF", []byte("PATCH\x00\x00\x01\x00\x01\x09"), "truncated"},
+		{"short data", []byte("PATCH\x00\x00\x01\x00\x04\x09EOF"), "truncated"},
+		{"past memory", []byte("PATCH\x00\xFF\xFF\x00\x01\x09EOF"), "past the end of memory"},
+	}
+	for _, tt := range tests {
+		if _, err := applyIPS([]byte{0, 1}, tt.patch); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// bpsPatch builds BPS patches for the tests.
+type bpsPatch struct {
+	bytes.Buffer
+}
+
+func (p *bpsPatch) number(n int) {
+	for {
+		b := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			p.WriteByte(b | 0x80)
+			return
+		}
+		p.WriteByte(b)
+		n--
+	}
+}
+
+func (p *bpsPatch) action(kind, length int) { p.number((length-1)<<2 | kind) }
+
+func (p *bpsPatch) signed(n int) {
+	if n < 0 {
+		p.number(-n<<1 | 1)
+	} else {
+		p.number(n << 1)
+	}
+}
+
+// finish adds the checksums of source and target and of the patch itself.
+func (p *bpsPatch) finish(source, target []byte) []byte {
+	binary.Write(p, binary.LittleEndian, crc32.ChecksumIEEE(source))
+	binary.Write(p, binary.LittleEndian, crc32.ChecksumIEEE(target))
+	binary.Write(p, binary.LittleEndian, crc32.ChecksumIEEE(p.Bytes()))
+	return p.Bytes()
+}
+
+var (
+	bpsSource = []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	bpsTarget = []byte{0, 1, 2, 3, 0xAA, 0xBB, 0, 1, 0, 1, 0}
+)
+
+// newBPSPatch returns a patch from bpsSource to bpsTarget using the four
+// kinds of actions.
+func newBPSPatch() []byte {
+	p := &bpsPatch{}
+	p.WriteString("BPS1")
+	p.number(len(bpsSource))
+	p.number(len(bpsTarget))
+	p.number(0)
+	p.action(0, 4) // 0 1 2 3 from the source
+	p.action(1, 2) // AA BB from the patch
+	p.WriteString("\xAA\xBB")
+	p.action(2, 2) // 0 1 copied from the start of the source
+	p.signed(0)
+	p.action(3, 3) // 0 1 0 copied from the result, overlapping what it writes
+	p.signed(6)
+	return p.finish(bpsSource, bpsTarget)
+}
+
+func TestApplyBPS(t *testing.T) {
+	got, err := applyBPS(bpsSource, newBPSPatch())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bpsTarget) {
+		t.Errorf("got %X, want %X", got, bpsTarget)
+	}
+}
+
+func TestApplyBPSErrors(t *testing.T) {
+	damaged := newBPSPatch()
+	damaged[len("BPS1")+4] ^= 0xFF
+
+	tests := []struct {
+		name  string
+		rom   []byte
+		patch []byte
+		want  string
+	}{
+		{"another ROM", []byte{7, 6, 5, 4, 3, 2, 1, 0}, newBPSPatch(), "made for another ROM"},
+		{"bad checksum", bpsSource, damaged, "bad checksum"},
+		{"too short", bpsSource, []byte("BPS1"), "truncated"},
+	}
+	for _, tt := range tests {
+		if _, err := applyBPS(tt.rom, tt.patch); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFindAndApplyPatch(t *testing.T) {
+	dir := t.TempDir()
+	rom := filepath.Join(dir, "game.ch8")
+	if err := os.WriteFile(rom, bpsSource, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if patch := findPatch(rom); patch != "" {
+		t.Errorf("found %s without a patch", patch)
+	}
+
+	bps := filepath.Join(dir, "game.bps")
+	if err := os.WriteFile(bps, newBPSPatch(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if patch := findPatch(rom); patch != bps {
+		t.Fatalf("found %q, want %s", patch, bps)
+	}
+	got, err := applyPatch(bpsSource, bps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bpsTarget) {
+		t.Errorf("got %X, want %X", got, bpsTarget)
+	}
+
+	other := filepath.Join(dir, "other.ips")
+	if err := os.WriteFile(other, []byte("not a patch"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyPatch(bpsSource, other); err == nil || !strings.Contains(err.Error(), "not an IPS or BPS patch") {
+		t.Errorf("error %v for a file that is not a patch", err)
+	}
+	if patch := findPatch(filepath.Join(dir, "games.zip", "game.ch8")); patch != "" {
+		t.Errorf("found %s for a ROM inside an archive", patch)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import "fmt"
+
+/*
+Flicker reduction for the display.
+
+CHIP-8 games erase sprites by drawing them again with XOR, so a moving sprite
+is off for part of every frame and flickers on a modern screen. The old CRT
+phosphor hid this because a pixel kept glowing for a while after the beam left.
+
+The screenFilter lives only in the rendering path: it reads cpu.gfx and keeps
+its own brightness buffer, so the emulation state is never modified.
+*/
+
+type persistenceMode int
+
+const (
+	persistenceOff      persistenceMode = iota // Pixels turn off instantly
+	persistencePhosphor                        // Pixels fade out over several frames
+	persistenceBlend                           // OR of the last two frames
+)
+
+var persistenceModes = map[string]persistenceMode{
+	"off":      persistenceOff,
+	"phosphor": persistencePhosphor,
+	"blend":    persistenceBlend,
+}
+
+type screenFilter struct {
+	mode       persistenceMode
+	decay      float32                 // Brightness lost per frame in phosphor mode
+	brightness [WIDTH * HEIGHT]float32 // Brightness of each pixel (0-1) sent to the renderer
+	previous   [WIDTH * HEIGHT]byte    // Last frame seen, used by the blend mode
+}
+
+func newScreenFilter(mode string, decay float64) (*screenFilter, error) {
+	m, ok := persistenceModes[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown persistence mode %q (use off, phosphor or blend)", mode)
+	}
+	if decay <= 0 || decay > 1 {
+		return nil, fmt.Errorf("persistence decay must be between 0 and 1, got %v", decay)
+	}
+	return &screenFilter{mode: m, decay: float32(decay)}, nil
+}
+
+// update must be called once per presented frame.
+func (f *screenFilter) update(gfx *[WIDTH * HEIGHT]byte) {
+	for i, p := range gfx {
+		switch f.mode {
+		case persistencePhosphor:
+			if p != 0 {
+				f.brightness[i] = 1
+			} else if f.brightness[i] > f.decay {
+				f.brightness[i] -= f.decay
+			} else {
+				f.brightness[i] = 0
+			}
+		case persistenceBlend:
+			if p != 0 || f.previous[i] != 0 {
+				f.brightness[i] = 1
+			} else {
+				f.brightness[i] = 0
+			}
+		default:
+			f.brightness[i] = float32(p)
+		}
+	}
+	f.previous = *gfx
+}
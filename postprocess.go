@@ -0,0 +1,241 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+/*
+Post-processing pipeline.
+
+The emulator screen is drawn into an offscreen framebuffer and then goes
+through a chain of fragment shaders before reaching the window. Every pass
+reads the texture written by the previous one, the last pass writes to the
+window.
+
+The chain is read from a JSON file:
+
+	{
+		"passes": [
+			{"shader": "scanlines", "params": {"strength": 0.5}},
+			{"shader": "curvature"},
+			{"file": "shaders/my_effect.frag"}
+		]
+	}
+
+"shader" selects one of the built-in shaders (scanlines, curvature, bloom,
+pixelgrid) and "file" loads a user fragment shader instead. Every pass gets
+the uniforms source (sampler2D), resolution and emuResolution (vec2), plus
+the float uniforms listed in "params".
+*/
+
+//go:embed shaders
+var builtinShaders embed.FS
+
+// Default values of the parameters used by the built-in shaders.
+var builtinShaderParams = map[string]map[string]float32{
+	"scanlines": {"strength": 0.35},
+	"curvature": {"amount": 0.08},
+	"bloom":     {"radius": 2.0, "strength": 0.6},
+	"pixelgrid": {"strength": 0.4, "width": 0.1},
+}
+
+type postConfig struct {
+	Passes []postPassConfig `json:"passes"`
+}
+
+type postPassConfig struct {
+	Shader string             `json:"shader"`
+	File   string             `json:"file"`
+	Params map[string]float32 `json:"params"`
+}
+
+type postPass struct {
+	name    string
+	program uint32
+	params  map[string]float32
+}
+
+type postPipeline struct {
+	passes []postPass
+	quad   uint32
+	fbo    [2]uint32 // Ping-pong framebuffers, the scene is drawn into fbo[0]
+	tex    [2]uint32
+	width  int32
+	height int32
+}
+
+func loadPostConfig(path string) (postConfig, error) {
+	var cfg postConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// No config file means no post-processing
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// newPostPipeline compiles the passes of the chain. Passes that fail to load
+// or compile are skipped, and nil is returned when none is left so the
+// screen is drawn directly to the window.
+func newPostPipeline(cfg postConfig) *postPipeline {
+	if len(cfg.Passes) == 0 {
+		return nil
+	}
+
+	vertexSource, err := builtinShaders.ReadFile("shaders/post.vert")
+	if err != nil {
+		fmt.Printf("Post-processing disabled: %v\n", err)
+		return nil
+	}
+
+	p := &postPipeline{}
+	for _, pc := range cfg.Passes {
+		pass, err := newPostPass(pc, string(vertexSource))
+		if err != nil {
+			fmt.Printf("Skipping post-processing pass %s: %v\n", pc.name(), err)
+			continue
+		}
+		p.passes = append(p.passes, pass)
+	}
+	if len(p.passes) == 0 {
+		fmt.Println("Post-processing disabled: no pass could be compiled")
+		return nil
+	}
+
+	// Two triangles covering the whole viewport
+	p.quad = makeVao([]float32{
+		-1, 1, 0,
+		-1, -1, 0,
+		1, -1, 0,
+
+		-1, 1, 0,
+		1, 1, 0,
+		1, -1, 0,
+	})
+	gl.GenFramebuffers(2, &p.fbo[0])
+	gl.GenTextures(2, &p.tex[0])
+	return p
+}
+
+func (pc postPassConfig) name() string {
+	if pc.File != "" {
+		return pc.File
+	}
+	return pc.Shader
+}
+
+func newPostPass(pc postPassConfig, vertexSource string) (postPass, error) {
+	var source []byte
+	var err error
+	params := map[string]float32{}
+
+	if pc.File != "" {
+		source, err = os.ReadFile(pc.File)
+	} else {
+		defaults, ok := builtinShaderParams[pc.Shader]
+		if !ok {
+			return postPass{}, fmt.Errorf("unknown built-in shader")
+		}
+		for k, v := range defaults {
+			params[k] = v
+		}
+		source, err = builtinShaders.ReadFile("shaders/" + pc.Shader + ".frag")
+	}
+	if err != nil {
+		return postPass{}, err
+	}
+	for k, v := range pc.Params {
+		params[k] = v
+	}
+
+	program, err := newProgram(vertexSource+"\x00", string(source)+"\x00")
+	if err != nil {
+		return postPass{}, err
+	}
+	return postPass{name: pc.name(), program: program, params: params}, nil
+}
+
+// resize recreates the offscreen textures when the window size changes.
+func (p *postPipeline) resize(width, height int32) error {
+	if width == p.width && height == p.height {
+		return nil
+	}
+	p.width, p.height = width, height
+
+	for i := range p.fbo {
+		gl.BindTexture(gl.TEXTURE_2D, p.tex[i])
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+		gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbo[i])
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, p.tex[i], 0)
+		if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+			return fmt.Errorf("offscreen framebuffer incomplete: 0x%X", status)
+		}
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	return nil
+}
+
+// begin redirects the drawing of the scene to the offscreen framebuffer,
+// which has the size of the window viewport. It returns false when the
+// pipeline can't be used, the scene is then drawn straight to the window.
+func (p *postPipeline) begin(vp viewport) bool {
+	if p == nil || vp.width == 0 || vp.height == 0 {
+		return false
+	}
+	if err := p.resize(vp.width, vp.height); err != nil {
+		fmt.Printf("Post-processing disabled: %v\n", err)
+		p.passes = nil
+	}
+	if len(p.passes) == 0 {
+		return false
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbo[0])
+	gl.Viewport(0, 0, p.width, p.height)
+	return true
+}
+
+// end runs the chain of passes over the scene, the last one draws to the
+// viewport of the window.
+func (p *postPipeline) end(vp viewport) {
+	gl.BindVertexArray(p.quad)
+	for i, pass := range p.passes {
+		if i == len(p.passes)-1 {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+			gl.Viewport(vp.x, vp.y, vp.width, vp.height)
+		} else {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbo[(i+1)%2])
+			gl.Clear(gl.COLOR_BUFFER_BIT)
+		}
+		gl.UseProgram(pass.program)
+
+		gl.ActiveTexture(gl.TEXTURE0)
+		gl.BindTexture(gl.TEXTURE_2D, p.tex[i%2])
+		gl.Uniform1i(uniformLocation(pass.program, "source"), 0)
+		gl.Uniform2f(uniformLocation(pass.program, "resolution"), float32(p.width), float32(p.height))
+		gl.Uniform2f(uniformLocation(pass.program, "emuResolution"), WIDTH, HEIGHT)
+		for name, value := range pass.params {
+			gl.Uniform1f(uniformLocation(pass.program, name), value)
+		}
+
+		gl.DrawArrays(gl.TRIANGLES, 0, 6)
+	}
+}
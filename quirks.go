@@ -0,0 +1,64 @@
+package main
+
+/*
+Quirks.
+
+CHIP-8 interpreters don't all run the same program the same way. The
+differences the emulator knows about are collected in quirks, the names are
+the ones of the CHIP-8 database (see romdb.go) and the quirks of every
+platform are in romdb/platforms.json.
+*/
+
+type quirks struct {
+	// 8XY6 and 8XYE shift VX in place, the VIP shifted VY into VX
+	Shift bool `json:"shift"`
+	// FX55 and FX65 leave I incremented by X instead of X+1
+	MemoryIncrementByX bool `json:"memoryIncrementByX"`
+	// FX55 and FX65 don't change I
+	MemoryLeaveIUnchanged bool `json:"memoryLeaveIUnchanged"`
+	// Sprites wrap around the edges of the screen instead of being clipped
+	Wrap bool `json:"wrap"`
+	// BNNN jumps to NNN plus VX (BXNN) instead of NNN plus V0
+	Jump bool `json:"jump"`
+	// DXYN waits for the next frame, so only one sprite is drawn per frame
+	VBlank bool `json:"vblank"`
+	// 8XY1, 8XY2 and 8XY3 reset VF to 0
+	Logic bool `json:"logic"`
+	// FX0A waits for a key to be pressed and released, like the COSMAC VIP.
+	// Without it the key is taken as soon as it is pressed, like SCHIP.
+	KeyWaitRelease bool `json:"keyWaitRelease"`
+}
+
+// set changes a quirk by its name in the database, false if there is no
+// quirk with that name.
+func (q *quirks) set(name string, value bool) bool {
+	switch name {
+	case "shift":
+		q.Shift = value
+	case "memoryIncrementByX":
+		q.MemoryIncrementByX = value
+	case "memoryLeaveIUnchanged":
+		q.MemoryLeaveIUnchanged = value
+	case "wrap":
+		q.Wrap = value
+	case "jump":
+		q.Jump = value
+	case "vblank":
+		q.VBlank = value
+	case "logic":
+		q.Logic = value
+	case "keyWaitRelease":
+		q.KeyWaitRelease = value
+	default:
+		return false
+	}
+	return true
+}
+
+// keyWait is the state of FX0A while the cpu waits for a key.
+type keyWait struct {
+	active   bool
+	register byte     // X of FX0A, receives the key
+	key      int      // Key pressed so far, -1 until there is one
+	held     [16]byte // Keys that were already down, they need a new press
+}
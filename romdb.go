@@ -0,0 +1,257 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ROM database.
+
+ROMs are looked up by their SHA-1 in a copy of the CHIP-8 database
+(https://github.com/chip-8/chip-8-database), which says for every known ROM
+the platform it was written for, the quirks it needs, its speed (tickrate,
+instructions per frame), the keys it uses and its colors. The profile found
+is applied when the ROM is loaded, flags given on the command line win over
+it.
+
+The database is three files in romdb/, embedded in the binary:
+platforms.json, programs.json and sha1-hashes.json. go generate downloads
+the last programs.json and sha1-hashes.json of the database, platforms.json
+is ours. -romdb points to a directory with newer files, the ones found there
+replace the embedded ones (programs.json and sha1-hashes.json together, the
+hashes are indexes in the programs).
+
+The quirk keyWaitRelease is ours and not part of the database.
+
+ROMs that are not in the database get a platform detected from their
+instructions, see detect.go.
+*/
+
+//go:generate curl -fsSL -o romdb/programs.json https://raw.githubusercontent.com/chip-8/chip-8-database/master/database/programs.json
+//go:generate curl -fsSL -o romdb/sha1-hashes.json https://raw.githubusercontent.com/chip-8/chip-8-database/master/database/sha1-hashes.json
+
+var (
+	//go:embed romdb/platforms.json
+	embeddedPlatforms []byte
+	//go:embed romdb/programs.json
+	embeddedPrograms []byte
+	//go:embed romdb/sha1-hashes.json
+	embeddedHashes []byte
+)
+
+type platformInfo struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	DefaultTickrate int    `json:"defaultTickrate"`
+	Quirks          quirks `json:"quirks"`
+}
+
+type programInfo struct {
+	Title   string             `json:"title"`
+	Authors []string           `json:"authors"`
+	ROMs    map[string]romInfo `json:"roms"`
+}
+
+type romInfo struct {
+	File            string                     `json:"file"`
+	Platforms       []string                   `json:"platforms"` // Best platform first
+	QuirkyPlatforms map[string]map[string]bool `json:"quirkyPlatforms"`
+	Tickrate        int                        `json:"tickrate"`
+	Keys            map[string]int             `json:"keys"` // Role of the key ("up", "a") to CHIP-8 key
+	Colors          struct {
+		Pixels []string `json:"pixels"` // Background and foreground first
+	} `json:"colors"`
+}
+
+type romDatabase struct {
+	platforms []platformInfo
+	programs  []programInfo
+	hashes    map[string]int // SHA-1 to index in programs
+}
+
+// loadROMDatabase reads the embedded database, with the files found in dir
+// replacing the embedded ones.
+func loadROMDatabase(dir string) (*romDatabase, error) {
+	db := &romDatabase{}
+	platforms, _, err := readROMDatabaseFile(dir, "platforms.json", embeddedPlatforms)
+	if err != nil {
+		return nil, err
+	}
+	programs, customPrograms, err := readROMDatabaseFile(dir, "programs.json", embeddedPrograms)
+	if err != nil {
+		return nil, err
+	}
+	hashes, customHashes, err := readROMDatabaseFile(dir, "sha1-hashes.json", embeddedHashes)
+	if err != nil {
+		return nil, err
+	}
+	// The hashes are indexes in the programs, both files must come from the
+	// same copy of the database
+	if customPrograms != customHashes {
+		return nil, fmt.Errorf("ROM database: %s needs both programs.json and sha1-hashes.json", dir)
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		v    any
+	}{
+		{"platforms.json", platforms, &db.platforms},
+		{"programs.json", programs, &db.programs},
+		{"sha1-hashes.json", hashes, &db.hashes},
+	}
+	for _, f := range files {
+		if err := json.Unmarshal(f.data, f.v); err != nil {
+			return nil, fmt.Errorf("ROM database %s: %v", f.name, err)
+		}
+	}
+	return db, nil
+}
+
+// readROMDatabaseFile returns the file name in dir, or the embedded copy
+// when dir is empty or doesn't have it. custom is true for the file in dir.
+func readROMDatabaseFile(dir, name string, embedded []byte) (data []byte, custom bool, err error) {
+	if dir == "" {
+		return embedded, false, nil
+	}
+	data, err = os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return embedded, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("ROM database: %v", err)
+	}
+	return data, true, nil
+}
+
+// loaded reports if the database has any program, the embedded copy is
+// empty until go generate downloads it.
+func (db *romDatabase) loaded() bool {
+	return len(db.hashes) > 0
+}
+
+func (db *romDatabase) platform(id string) (platformInfo, bool) {
+	for _, p := range db.platforms {
+		if strings.EqualFold(p.ID, id) {
+			return p, true
+		}
+	}
+	return platformInfo{}, false
+}
+
+// Short names accepted by -platform
+var platformAliases = map[string]string{
+	"vip":   "originalChip8",
+	"chip8": "modernChip8",
+	"schip": "superchip",
+}
+
+// platformByName returns the platform selected with -platform.
+func (db *romDatabase) platformByName(name string) (platformInfo, error) {
+	if id, ok := platformAliases[strings.ToLower(name)]; ok {
+		name = id
+	}
+	p, ok := db.platform(name)
+	if !ok {
+		var ids []string
+		for _, p := range db.platforms {
+			ids = append(ids, p.ID)
+		}
+		return p, fmt.Errorf("unknown platform %q (use vip, schip or one of %s)", name, strings.Join(ids, ", "))
+	}
+	return p, nil
+}
+
+// romProfile is the setup of a ROM.
+type romProfile struct {
+	title    string
+	platform platformInfo
+	quirks   quirks
+	tickrate int
+	keys     map[string]int
+	palette  *palette
+	source   string // Where the profile comes from, for the log
+}
+
+// lookup finds the ROM with the given SHA-1.
+func (db *romDatabase) lookup(hash string) (romProfile, bool) {
+	hash = strings.ToLower(hash)
+	index, ok := db.hashes[hash]
+	if !ok || index < 0 || index >= len(db.programs) {
+		return romProfile{}, false
+	}
+	program := db.programs[index]
+	rom, ok := program.ROMs[hash]
+	if !ok {
+		return romProfile{}, false
+	}
+
+	profile := romProfile{title: program.Title, keys: rom.Keys, source: "ROM database"}
+	// Use the first platform of the ROM that the emulator knows
+	for _, id := range rom.Platforms {
+		if p, ok := db.platform(id); ok {
+			profile.platform = p
+			break
+		}
+	}
+	if profile.platform.ID == "" {
+		return romProfile{}, false
+	}
+	profile.quirks = profile.platform.Quirks
+	for name, value := range rom.QuirkyPlatforms[profile.platform.ID] {
+		if !profile.quirks.set(name, value) {
+			fmt.Printf("ROM database: unknown quirk %q for %s\n", name, program.Title)
+		}
+	}
+	profile.tickrate = rom.Tickrate
+	if profile.tickrate == 0 {
+		profile.tickrate = profile.platform.DefaultTickrate
+	}
+	if len(rom.Colors.Pixels) >= 2 {
+		pal, err := parsePalette(strings.Join(rom.Colors.Pixels[:2], ","))
+		if err == nil {
+			profile.palette = &pal
+		}
+	}
+	return profile, true
+}
+
+// guessProfile picks a platform for a ROM missing from the database, see
+// detect.go.
+func (db *romDatabase) guessProfile(rom []byte) romProfile {
+	d := detectPlatform(rom)
+	p, _ := db.platform(d.platform)
+	return romProfile{
+		platform: p,
+		quirks:   p.Quirks,
+		tickrate: p.DefaultTickrate,
+		source:   fmt.Sprintf("detected, %.0f%% confidence: %s", d.confidence*100, strings.Join(d.reasons, "; ")),
+	}
+}
+
+// profileRoleKeys are the keyboard keys bound to the roles of the keys in
+// the database, next to the usual bindings.
+var profileRoleKeys = map[string]string{
+	"up":    "Up",
+	"down":  "Down",
+	"left":  "Left",
+	"right": "Right",
+	"a":     "Space",
+	"b":     "Enter",
+}
+
+// keyBindings turns the keys of the profile into bindings for the keyMap.
+func (p romProfile) keyBindings() map[byte][]string {
+	bindings := map[byte][]string{}
+	for role, chip8Key := range p.keys {
+		if name, ok := profileRoleKeys[role]; ok && chip8Key >= 0 && chip8Key < 16 {
+			bindings[byte(chip8Key)] = append(bindings[byte(chip8Key)], name)
+		}
+	}
+	return bindings
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeROMDatabase(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadROMDatabaseOverride(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	dir := writeROMDatabase(t, map[string]string{
+		"programs.json":    `[{"title": "Test", "roms": {"` + hash + `": {"platforms": ["superchip"], "tickrate": 25}}}]`,
+		"sha1-hashes.json": `{"` + hash + `": 0}`,
+	})
+	db, err := loadROMDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.loaded() {
+		t.Error("the programs of -romdb are not loaded")
+	}
+	// The platforms are still the embedded ones
+	if _, ok := db.platform("xochip"); !ok {
+		t.Error("embedded platforms missing")
+	}
+	p, ok := db.lookup(strings.ToUpper(hash))
+	if !ok {
+		t.Fatal("ROM not found")
+	}
+	if p.title != "Test" || p.platform.ID != "superchip" || p.tickrate != 25 {
+		t.Errorf("got %s on %s at %d", p.title, p.platform.ID, p.tickrate)
+	}
+}
+
+func TestLoadROMDatabaseNeedsBothFiles(t *testing.T) {
+	dir := writeROMDatabase(t, map[string]string{"programs.json": `[]`})
+	if _, err := loadROMDatabase(dir); err == nil || !strings.Contains(err.Error(), "needs both") {
+		t.Errorf("error %v with only programs.json", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+ROM menu.
+
+Lists the ROMs of -romdir over the game, F1 opens it at any time. Up and
+Down (or Page Up, Page Down, Home and End) move the selection, Enter loads
+the ROM or opens the archive and Escape goes back to the game. Backspace
+goes back from an archive to its directory. The emulation is paused while the
+menu is open.
+*/
+
+// romMenuDisplay is implemented by the displays with a ROM menu.
+type romMenuDisplay interface {
+	OpenMenu()
+	// MenuOpen reports if the menu covers the game.
+	MenuOpen() bool
+	// ChosenROM returns the ROM picked in the menu, or dropped on the
+	// window, since the last call.
+	ChosenROM() (string, bool)
+}
+
+var (
+	menuBackgroundPalette = palette{foreground: color.RGBA{0x10, 0x10, 0x18, 0xFF}}
+	menuSelectionPalette  = palette{foreground: color.RGBA{0x30, 0x50, 0x90, 0xFF}}
+)
+
+type romMenu struct {
+	loader   *romLoader
+	dir      string
+	open     bool
+	canClose bool // False until a game is loaded, there is nothing to go back to
+	roms     []romEntry
+	selected int
+	err      error // Error reading the directory
+	chosen   string
+}
+
+func (m *romMenu) show() {
+	current := ""
+	if m.selected < len(m.roms) {
+		current = m.roms[m.selected].path
+	}
+	m.roms, m.err = m.loader.listROMs(m.dir)
+	m.selected = 0
+	for i, r := range m.roms {
+		if r.path == current {
+			m.selected = i
+		}
+	}
+	m.open = true
+}
+
+// handleKey moves in the menu, rows is the number of ROMs on screen.
+func (m *romMenu) handleKey(key glfw.Key, rows int) {
+	switch key {
+	case glfw.KeyUp:
+		m.selected--
+	case glfw.KeyDown:
+		m.selected++
+	case glfw.KeyPageUp:
+		m.selected -= rows
+	case glfw.KeyPageDown:
+		m.selected += rows
+	case glfw.KeyHome:
+		m.selected = 0
+	case glfw.KeyEnd:
+		m.selected = len(m.roms) - 1
+	case glfw.KeyEnter, glfw.KeyKPEnter:
+		switch {
+		case m.selected >= len(m.roms):
+		case m.roms[m.selected].library:
+			m.dir = m.roms[m.selected].path
+			m.show()
+		default:
+			m.chosen = m.roms[m.selected].path
+			m.open = false
+		}
+	case glfw.KeyBackspace:
+		// Back from an archive to the directory that has it
+		if archive, _, ok := splitArchivePath(m.dir); ok {
+			m.dir = filepath.Dir(archive)
+			m.show()
+		}
+	case glfw.KeyEscape:
+		if m.canClose {
+			m.open = false
+		}
+	}
+	m.selected = max(0, min(m.selected, len(m.roms)-1))
+}
+
+// menuLayout returns the text scale, the height of a line and the number of
+// ROMs that fit in the window.
+func menuLayout(ws *windowState) (scale, lineHeight, rows int) {
+	scale = max(1, ws.fbHeight/200)
+	lineHeight = (glyphHeight + 4) * scale
+	rows = max(1, ws.fbHeight/lineHeight-4) // Title and help lines
+	return scale, lineHeight, rows
+}
+
+func drawMenu(ws *windowState, program uint32, m *romMenu) {
+	scale, lineHeight, rows := menuLayout(ws)
+	margin := 2 * lineHeight / 3
+
+	var background, selection, text textBatch
+	for _, b := range []*textBatch{&background, &selection, &text} {
+		b.fbWidth, b.fbHeight = ws.fbWidth, ws.fbHeight
+	}
+	background.addRect(0, 0, ws.fbWidth, ws.fbHeight)
+
+	text.add(margin, margin, scale, "ROMs in "+m.dir)
+	top := margin + 2*lineHeight
+	switch {
+	case m.err != nil:
+		text.add(margin, top, scale, m.err.Error())
+	case len(m.roms) == 0:
+		text.add(margin, top, scale, "No ROMs found")
+	}
+
+	// Scroll to keep the selection in the middle of the list
+	first := max(0, min(m.selected-rows/2, len(m.roms)-rows))
+	for i := first; i < min(len(m.roms), first+rows); i++ {
+		y := top + (i-first)*lineHeight
+		if i == m.selected {
+			selection.addRect(0, y-2*scale, ws.fbWidth, lineHeight)
+		}
+		text.add(margin, y, scale, m.roms[i].title)
+	}
+
+	help := "Up/Down: select   Enter: load"
+	if _, _, ok := splitArchivePath(m.dir); ok {
+		help += "   Backspace: up"
+	}
+	if m.canClose {
+		help += "   Esc: back"
+	}
+	text.add(margin, ws.fbHeight-margin-glyphHeight*scale, scale, fmt.Sprintf("%s   (%d ROMs)", help, len(m.roms)))
+
+	gl.Viewport(0, 0, int32(ws.fbWidth), int32(ws.fbHeight))
+	background.draw(program, menuBackgroundPalette)
+	selection.draw(program, menuSelectionPalette)
+	text.draw(program, osdTextPalette)
+	ws.applyViewport()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+ROM loading.
+
+Loading a ROM resets the cpu and sets up everything that depends on the
+ROM: quirks, speed, colors and key bindings, from the options of an Octo
+cartridge, the ROM database or the detected platform, with the config file
+and the command line flags winning over them. It is the same at startup and
+when another game is picked in the menu.
+*/
+
+// romLoader keeps what is needed to set up every ROM.
+type romLoader struct {
+	db       *romDatabase
+	keyCfg   keyConfig
+	config   *userConfig       // Settings saved for every ROM
+	explicit map[string]bool   // Flags given on the command line, they win over the config
+	patches  map[string]string // ROM path to the patch given with -patch
+	platform string            // -platform or its setting, empty to use the profile of the ROM
+	ipf      int               // -ipf or its setting, 0 to use the profile of the ROM
+	palette  *palette          // -palette or its setting, nil to use the profile of the ROM
+
+	defaultIPF     int
+	defaultPalette palette
+}
+
+// romSetup is a loaded ROM and its settings.
+type romSetup struct {
+	path    string
+	name    string // Title from the database, or the file name
+	hash    string // SHA-1 of the ROM, before the patch if there is one
+	data    []byte // Contents, with the patch applied
+	profile romProfile
+	ipf     int
+	palette palette
+	keymap  *keyMap
+}
+
+// load resets the cpu and loads the ROM at path into it.
+func (l *romLoader) load(c *cpu, path string) (*romSetup, error) {
+	// The file and its settings are checked before the cpu is reset, a bad
+	// file or config doesn't stop the game being played
+	data, cart, err := readROM(path)
+	if err != nil {
+		return nil, err
+	}
+	original := data
+	patch := l.patches[path]
+	if patch == "" {
+		patch = findPatch(path)
+	}
+	if patch != "" {
+		if data, err = applyPatch(data, patch); err != nil {
+			return nil, fmt.Errorf("error loading game: %v", err)
+		}
+	}
+	// The settings of the ROM are the ones of the game before the patch
+	sum := sha1.Sum(original)
+	hash := hex.EncodeToString(sum[:])
+
+	profile, ok := l.db.lookup(hash)
+	switch {
+	case cart != nil:
+		// The options saved with the program win over the database
+		profile = cart.Options.profile(l.db)
+	case !ok:
+		profile = l.db.guessProfile(data)
+	}
+	if l.platform != "" {
+		p, err := l.db.platformByName(l.platform)
+		if err != nil {
+			return nil, err
+		}
+		profile.platform, profile.quirks, profile.tickrate = p, p.Quirks, p.DefaultTickrate
+		profile.source = "-platform"
+	}
+	// The section of the ROM wins over "settings", not over the command line
+	saved, _ := l.config.rom(hash)
+	if !l.explicit["platform"] && saved.Platform != "" {
+		p, err := l.db.platformByName(saved.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("config of %s: %v", path, err)
+		}
+		profile.platform, profile.quirks, profile.tickrate = p, p.Quirks, p.DefaultTickrate
+		profile.source = "config"
+	}
+	keymap, err := buildKeyMap(l.keyCfg, hash, profile.keyBindings())
+	if err != nil {
+		return nil, err
+	}
+	setup := &romSetup{
+		path:    path,
+		name:    filepath.Base(path),
+		hash:    hash,
+		data:    data,
+		profile: profile,
+		ipf:     l.defaultIPF,
+		palette: l.defaultPalette,
+		keymap:  keymap,
+	}
+	if profile.title != "" {
+		setup.name = profile.title
+	}
+	switch {
+	case l.ipf > 0 && (l.explicit["ipf"] || saved.IPF == 0):
+		setup.ipf = l.ipf
+	case saved.IPF > 0:
+		setup.ipf = saved.IPF
+	case profile.tickrate > 0:
+		setup.ipf = profile.tickrate
+	}
+	switch {
+	case l.palette != nil && (l.explicit["palette"] || saved.Palette == ""):
+		setup.palette = *l.palette
+	case saved.Palette != "":
+		pal, err := parsePalette(saved.Palette)
+		if err != nil {
+			return nil, fmt.Errorf("config of %s: %v", path, err)
+		}
+		setup.palette = pal
+	case profile.palette != nil:
+		setup.palette = *profile.palette
+	}
+
+	c.initEmulator()
+	c.loadGame(data)
+	c.quirks = profile.quirks
+	if patch != "" {
+		fmt.Printf("ROM %s: patched with %s\n", path, patch)
+	}
+	fmt.Printf("ROM %s: platform %s (%s)\n", path, profile.platform.ID, profile.source)
+	return setup, nil
+}
+
+// romDisplay is implemented by the backends that change with the ROM, they
+// get the key bindings and colors of every ROM loaded.
+type romDisplay interface {
+	SetROM(rom *romSetup)
+}
+
+// romEntry is a ROM listed in the menu.
+type romEntry struct {
+	path    string
+	title   string
+	library bool // An archive with several ROMs, opened in the menu
+}
+
+// listROMs returns the ROMs in dir sorted by title, with the titles from
+// the database when the ROM is in it. dir can be a zip archive.
+func (l *romLoader) listROMs(dir string) ([]romEntry, error) {
+	var roms []romEntry
+	if _, _, ok := splitArchivePath(dir); ok {
+		var err error
+		if roms, err = l.listArchive(dir); err != nil {
+			return nil, err
+		}
+	} else {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(dir, f.Name())
+			if strings.EqualFold(filepath.Ext(path), archiveExtension) {
+				roms = append(roms, l.archiveEntry(path))
+				continue
+			}
+			if !isROMFile(path) {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil || !isListedROM(path, data) {
+				continue
+			}
+			roms = append(roms, romEntry{path: path, title: l.title(data, f.Name())})
+		}
+	}
+	sort.Slice(roms, func(i, j int) bool {
+		return strings.ToLower(roms[i].title) < strings.ToLower(roms[j].title)
+	})
+	return roms, nil
+}
+
+// title returns the title of a ROM in the database, or name.
+func (l *romLoader) title(data []byte, name string) string {
+	sum := sha1.Sum(data)
+	if p, ok := l.db.lookup(hex.EncodeToString(sum[:])); ok && p.title != "" {
+		return p.title
+	}
+	return name
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	db, err := loadROMDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := []byte{0x60, 0x01, 0x12, 0x02}
+	path := filepath.Join(t.TempDir(), "test.ch8")
+	if err := os.WriteFile(path, rom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(rom)
+	section := map[string]romUserConfig{hex.EncodeToString(sum[:]): {IPF: 30, Platform: "schip"}}
+
+	tests := []struct {
+		name     string
+		ipf      int // -ipf or the setting
+		explicit bool
+		roms     map[string]romUserConfig
+		want     int
+		platform string
+	}{
+		{"profile", 0, false, nil, 15, "originalChip8"},
+		{"setting over the profile", 20, false, nil, 20, "originalChip8"},
+		{"ROM section over the setting", 20, false, section, 30, "superchip"},
+		{"command line over the ROM section", 40, true, section, 40, "superchip"},
+	}
+	for _, tt := range tests {
+		l := &romLoader{
+			db:             db,
+			config:         &userConfig{ROMs: tt.roms},
+			explicit:       map[string]bool{"ipf": tt.explicit},
+			ipf:            tt.ipf,
+			defaultIPF:     10,
+			defaultPalette: defaultPalette,
+		}
+		setup, err := l.load(&cpu{}, path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if setup.ipf != tt.want || setup.profile.platform.ID != tt.platform {
+			t.Errorf("%s: %d instructions per frame on %s, want %d on %s", tt.name, setup.ipf, setup.profile.platform.ID, tt.want, tt.platform)
+		}
+	}
+}
+
+func TestListROMsSkipsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"pong.ch8":    "\x12\x00",
+		"blitz.sc8":   "\x00\xFF\x12\x02",
+		"README":      "Games for the emulator\n",
+		"Makefile":    "all:\n",
+		"notes.txt":   "text",
+		".hidden.ch8": "\x12\x00",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := loadROMDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roms, err := (&romLoader{db: db}).listROMs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, rom := range roms {
+		titles = append(titles, rom.title)
+	}
+	if len(titles) != 2 || titles[0] != "blitz.sc8" || titles[1] != "pong.ch8" {
+		t.Errorf("listed %v, want blitz.sc8 and pong.ch8", titles)
+	}
+}
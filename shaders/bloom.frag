@@ -0,0 +1,18 @@
+#version 330 core
+// Adds a soft glow around the lit pixels.
+in vec2 uv;
+out vec4 frag_colour;
+uniform sampler2D source;
+uniform float radius;
+uniform float strength;
+void main() {
+	vec2 texel = radius / vec2(textureSize(source, 0));
+	vec4 glow = vec4(0.0);
+	for (int x = -2; x <= 2; x++) {
+		for (int y = -2; y <= 2; y++) {
+			glow += texture(source, uv + vec2(x, y) * texel);
+		}
+	}
+	vec4 colour = texture(source, uv) + glow / 25.0 * strength;
+	frag_colour = vec4(colour.rgb, 1.0);
+}
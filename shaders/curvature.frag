@@ -0,0 +1,16 @@
+#version 330 core
+// Bends the image like the glass of an old monitor.
+in vec2 uv;
+out vec4 frag_colour;
+uniform sampler2D source;
+uniform float amount;
+void main() {
+	vec2 c = uv * 2.0 - 1.0;
+	c += c * (c.yx * c.yx) * amount;
+	vec2 p = c * 0.5 + 0.5;
+	if (p.x < 0.0 || p.x > 1.0 || p.y < 0.0 || p.y > 1.0) {
+		frag_colour = vec4(0.0, 0.0, 0.0, 1.0);
+		return;
+	}
+	frag_colour = texture(source, p);
+}
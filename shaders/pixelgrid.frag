@@ -0,0 +1,15 @@
+#version 330 core
+// Draws a thin grid between the emulated pixels.
+in vec2 uv;
+out vec4 frag_colour;
+uniform sampler2D source;
+uniform vec2 emuResolution;
+uniform float strength;
+uniform float width;
+void main() {
+	vec2 cell = fract(uv * emuResolution);
+	float inside = step(width, cell.x) * step(width, cell.y);
+	vec4 colour = texture(source, uv);
+	colour.rgb *= mix(1.0 - strength, 1.0, inside);
+	frag_colour = colour;
+}
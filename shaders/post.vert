@@ -0,0 +1,8 @@
+#version 330 core
+// Full screen quad shared by every post-processing pass.
+layout(location = 0) in vec3 vp;
+out vec2 uv;
+void main() {
+	uv = vp.xy * 0.5 + 0.5;
+	gl_Position = vec4(vp, 1.0);
+}
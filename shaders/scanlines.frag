@@ -0,0 +1,13 @@
+#version 330 core
+// Darkens the space between the emulated rows like a CRT beam.
+in vec2 uv;
+out vec4 frag_colour;
+uniform sampler2D source;
+uniform vec2 emuResolution;
+uniform float strength;
+void main() {
+	vec4 colour = texture(source, uv);
+	float scan = 0.5 + 0.5 * cos(uv.y * emuResolution.y * 6.28318);
+	colour.rgb *= 1.0 - strength * scan;
+	frag_colour = colour;
+}
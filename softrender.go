@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Software renderer.
+
+Turns the gfx buffer into an image without GLFW or OpenGL, so the emulator can
+run headless (CI, servers, tests) and take screenshots.
+*/
+
+type palette struct {
+	background color.RGBA
+	foreground color.RGBA
+}
+
+var defaultPalette = palette{
+	background: color.RGBA{0x00, 0x00, 0x00, 0xFF},
+	foreground: color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+// parsePalette reads a palette written as "background,foreground" in hex,
+// for example "000000,FFFFFF".
+func parsePalette(s string) (palette, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return palette{}, fmt.Errorf("palette %q must have two colors: background,foreground", s)
+	}
+	var colors [2]color.RGBA
+	for i, part := range parts {
+		part = strings.TrimPrefix(strings.TrimSpace(part), "#")
+		v, err := strconv.ParseUint(part, 16, 32)
+		if err != nil || len(part) != 6 {
+			return palette{}, fmt.Errorf("invalid color %q in palette, use RRGGBB", part)
+		}
+		colors[i] = color.RGBA{byte(v >> 16), byte(v >> 8), byte(v), 0xFF}
+	}
+	return palette{background: colors[0], foreground: colors[1]}, nil
+}
+
+func (p palette) colors() color.Palette {
+	return color.Palette{p.background, p.foreground}
+}
+
+type softRenderer struct {
+	scale   int // Size of each CHIP-8 pixel in the image
+	palette palette
+}
+
+func (r softRenderer) render(gfx *[WIDTH * HEIGHT]byte) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, WIDTH*r.scale, HEIGHT*r.scale), r.palette.colors())
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			if gfx[y*WIDTH+x] == 0 {
+				continue
+			}
+			for sy := 0; sy < r.scale; sy++ {
+				row := img.Pix[(y*r.scale+sy)*img.Stride:]
+				for sx := 0; sx < r.scale; sx++ {
+					row[x*r.scale+sx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+func (r softRenderer) savePNG(gfx *[WIDTH * HEIGHT]byte, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, r.render(gfx)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// screenshot saves the current screen with a timestamped name, used by the hotkey.
+func (r softRenderer) screenshot(gfx *[WIDTH * HEIGHT]byte) {
+	path := "screenshot-" + time.Now().Format("20060102-150405.000") + ".png"
+	if err := r.savePNG(gfx, path); err != nil {
+		notify("Error saving screenshot: %v", err)
+		return
+	}
+	notify("Screenshot saved to %s", path)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+Terminal frontend.
+
+Draws the screen with Unicode half-blocks: every character cell shows two
+pixels, the top one with the foreground color of the '▀' and the bottom one
+with the background color. A 64x32 screen needs 64x16 cells and 128x64
+needs 128x32.
+
+The terminal is put in raw mode with stty so keys arrive without Enter.
+Terminals only send key presses (and auto-repeat), never releases, so a key
+stays pressed for keyHoldFrames frames after the last byte received. The
+arrows and the other special keys come as escape sequences (ESC [ A for Up)
+and are bound with their GLFW names ("Up", "Home"), like in the window.
+
+The ROM menu is drawn as text, F1 opens it and it is used with the same keys
+as in the window. Escape goes back from the menu and quits the game, Ctrl-C
+always quits.
+*/
+
+const (
+	keyHoldFrames = 12
+	menuRows      = HEIGHT/2 - 4 // ROMs shown in the menu, the screen size less the title and help
+)
+
+// terminalKey is a key read from the terminal: a typed character, or a
+// special key with char 0.
+type terminalKey struct {
+	char rune
+	key  glfw.Key
+}
+
+// Special keys sent as escape sequences, after ESC [ or ESC O
+var terminalSequences = map[string]glfw.Key{
+	"A": glfw.KeyUp, "B": glfw.KeyDown, "C": glfw.KeyRight, "D": glfw.KeyLeft,
+	"H": glfw.KeyHome, "F": glfw.KeyEnd, "1~": glfw.KeyHome, "4~": glfw.KeyEnd,
+	"5~": glfw.KeyPageUp, "6~": glfw.KeyPageDown, "P": glfw.KeyF1, "11~": glfw.KeyF1,
+}
+
+// parseTerminalKeys splits the bytes read from the terminal into keys.
+// Unknown escape sequences are dropped.
+func parseTerminalKeys(data []byte) []terminalKey {
+	var keys []terminalKey
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b == 0x1b && i+1 < len(data) && (data[i+1] == '[' || data[i+1] == 'O'):
+			// The sequence ends with a letter or ~
+			end := i + 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7E) {
+				end++
+			}
+			if end == len(data) {
+				return keys
+			}
+			if key, ok := terminalSequences[string(data[i+2:end+1])]; ok {
+				keys = append(keys, terminalKey{key: key})
+			}
+			i = end
+		case b == 0x1b:
+			keys = append(keys, terminalKey{key: glfw.KeyEscape})
+		case b == '\r' || b == '\n':
+			keys = append(keys, terminalKey{key: glfw.KeyEnter})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, terminalKey{key: glfw.KeyBackspace})
+		default:
+			keys = append(keys, terminalKey{char: rune(b)})
+		}
+	}
+	return keys
+}
+
+// terminalFrontend is the Display and the Input of the terminal.
+type terminalFrontend struct {
+	palette  palette
+	keymap   *keyMap
+	out      *bufio.Writer
+	sttyMode string           // Terminal settings restored on exit
+	keys     chan terminalKey // Mapped to the keypad in Update, closed at the end of stdin
+	quit     bool
+	held     [16]int // Frames left until each key is released
+	menu     *romMenu
+}
+
+func newTerminalFrontend(pal palette, keymap *keyMap, menu *romMenu) (*terminalFrontend, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+
+	t := &terminalFrontend{
+		palette:  pal,
+		keymap:   keymap,
+		out:      bufio.NewWriterSize(os.Stdout, 64*1024),
+		sttyMode: strings.TrimSpace(saved),
+		keys:     make(chan terminalKey, 64),
+		menu:     menu,
+	}
+	// Alternate screen and hidden cursor
+	t.out.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	t.out.Flush()
+
+	go t.readKeys()
+	return t, nil
+}
+
+func (t *terminalFrontend) SetROM(rom *romSetup) {
+	t.keymap = rom.keymap
+	t.palette = rom.palette
+	t.menu.canClose = true
+}
+
+func (t *terminalFrontend) OpenMenu() {
+	t.menu.show()
+}
+
+func (t *terminalFrontend) MenuOpen() bool {
+	return t.menu.open
+}
+
+func (t *terminalFrontend) ChosenROM() (string, bool) {
+	path := t.menu.chosen
+	t.menu.chosen = ""
+	return path, path != ""
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (t *terminalFrontend) Close() {
+	t.out.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	stty(t.sttyMode)
+}
+
+// readKeys runs in its own goroutine, stdin reads block. It doesn't use the
+// keymap or the menu, they change from the main goroutine.
+func (t *terminalFrontend) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(t.keys)
+			return
+		}
+		for _, k := range parseTerminalKeys(buf[:n]) {
+			t.keys <- k
+		}
+	}
+}
+
+// Update applies the keys typed since the last frame.
+func (t *terminalFrontend) Update(keys *[16]byte) {
+	for i := range t.held {
+		if t.held[i] > 0 {
+			t.held[i]--
+		}
+	}
+drain:
+	for {
+		select {
+		case k, ok := <-t.keys:
+			if !ok {
+				t.quit = true
+				break drain
+			}
+			t.handleKey(k)
+		default:
+			break drain
+		}
+	}
+	for i := range t.held {
+		if t.held[i] > 0 {
+			keys[i] = 1
+		} else {
+			keys[i] = 0
+		}
+	}
+}
+
+// handleKey sends a key to the menu when it is open, to the keypad
+// otherwise.
+func (t *terminalFrontend) handleKey(k terminalKey) {
+	switch {
+	case k.char == 0x03:
+		t.quit = true
+	case t.menu.open && k.char == 0:
+		if k.key == glfw.KeyEscape && !t.menu.canClose {
+			t.quit = true
+			return
+		}
+		t.menu.handleKey(k.key, menuRows)
+	case t.menu.open:
+	case k.key == glfw.KeyEscape:
+		t.quit = true
+	case k.key == glfw.KeyF1:
+		t.menu.show()
+	case k.char != 0:
+		if symbol, ok := t.keymap.lookupChar(k.char); ok {
+			t.held[symbol] = keyHoldFrames
+		}
+	default:
+		if symbol, ok := t.keymap.keys[k.key]; ok {
+			t.held[symbol] = keyHoldFrames
+		}
+	}
+}
+
+func (t *terminalFrontend) Present(gfx *[WIDTH * HEIGHT]byte) {
+	if t.menu.open {
+		t.drawMenu()
+		return
+	}
+	t.draw(gfx[:], WIDTH, HEIGHT, t.palette)
+}
+
+func (t *terminalFrontend) ShouldClose() bool {
+	return t.quit
+}
+
+// drawMenu shows the ROM menu as text, in the place of the screen.
+func (t *terminalFrontend) drawMenu() {
+	m := t.menu
+	t.out.WriteString("\x1b[H\x1b[0m")
+	line := func(format string, args ...any) {
+		fmt.Fprintf(t.out, format, args...)
+		t.out.WriteString("\x1b[0m\x1b[K\r\n")
+	}
+	line("ROMs in %s", m.dir)
+	line("")
+	switch {
+	case m.err != nil:
+		line("%v", m.err)
+	case len(m.roms) == 0:
+		line("No ROMs found")
+	}
+	// Scroll to keep the selection in the middle of the list
+	first := max(0, min(m.selected-menuRows/2, len(m.roms)-menuRows))
+	for i := first; i < min(len(m.roms), first+menuRows); i++ {
+		if i == m.selected {
+			line("\x1b[7m> %s", m.roms[i].title)
+		} else {
+			line("  %s", m.roms[i].title)
+		}
+	}
+	help := "Up/Down: select   Enter: load"
+	if _, _, ok := splitArchivePath(m.dir); ok {
+		help += "   Backspace: up"
+	}
+	if m.canClose {
+		help += "   Esc: back"
+	}
+	line("")
+	line("%s   (%d ROMs)", help, len(m.roms))
+	t.out.WriteString("\x1b[J")
+	t.out.Flush()
+}
+
+// draw redraws the whole screen in place. pixels is a width*height buffer
+// like gfx, height must be even.
+func (t *terminalFrontend) draw(pixels []byte, width, height int, pal palette) {
+	colors := [2]string{
+		fmt.Sprintf("%d;%d;%d", pal.background.R, pal.background.G, pal.background.B),
+		fmt.Sprintf("%d;%d;%d", pal.foreground.R, pal.foreground.G, pal.foreground.B),
+	}
+
+	t.out.WriteString("\x1b[H")
+	for y := 0; y < height; y += 2 {
+		last := [2]int{-1, -1}
+		for x := 0; x < width; x++ {
+			top := int(pixels[y*width+x] & 1)
+			bottom := int(pixels[(y+1)*width+x] & 1)
+			// Only send colors when they change, it keeps the output small
+			if top != last[0] {
+				fmt.Fprintf(t.out, "\x1b[38;2;%sm", colors[top])
+			}
+			if bottom != last[1] {
+				fmt.Fprintf(t.out, "\x1b[48;2;%sm", colors[bottom])
+			}
+			last = [2]int{top, bottom}
+			t.out.WriteString("▀")
+		}
+		// Raw mode doesn't turn \n into \r\n
+		t.out.WriteString("\x1b[0m\r\n")
+	}
+	t.out.Flush()
+}
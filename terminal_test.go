@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image/color"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+func TestParseTerminalKeys(t *testing.T) {
+	got := parseTerminalKeys([]byte("\x1b[A\x1b[Bw\x1b[C\x1b[D\x1bOA\x1b[5~\x1b[2;5D\r\x7f\x1b"))
+	want := []terminalKey{
+		{key: glfw.KeyUp}, {key: glfw.KeyDown}, {char: 'w'}, {key: glfw.KeyRight}, {key: glfw.KeyLeft},
+		{key: glfw.KeyUp}, {key: glfw.KeyPageUp}, {key: glfw.KeyEnter}, {key: glfw.KeyBackspace},
+		{key: glfw.KeyEscape},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTerminalDrawHighRes(t *testing.T) {
+	const width, height = 128, 64
+	pixels := make([]byte, width*height)
+	// Top pixel of the first cell, bottom pixel of the last one, and both
+	// pixels of a cell in the middle
+	pixels[0] = 1
+	pixels[(height-1)*width+width-1] = 1
+	pixels[20*width+70], pixels[21*width+70] = 1, 1
+
+	var buf bytes.Buffer
+	term := &terminalFrontend{out: bufio.NewWriter(&buf)}
+	pal := palette{background: color.RGBA{1, 2, 3, 255}, foreground: color.RGBA{4, 5, 6, 255}}
+	term.draw(pixels, width, height, pal)
+
+	out := strings.TrimPrefix(buf.String(), "\x1b[H")
+	rows := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	if len(rows) != height/2 {
+		t.Fatalf("%d rows, want %d", len(rows), height/2)
+	}
+
+	// Colors of every cell, following the escapes
+	escape := regexp.MustCompile(`^\x1b\[(38|48);2;([0-9;]+)m`)
+	colors := map[string]byte{"1;2;3": 0, "4;5;6": 1}
+	for y, row := range rows {
+		row = strings.TrimSuffix(row, "\x1b[0m")
+		var fg, bg byte
+		cells := 0
+		for row != "" {
+			if m := escape.FindStringSubmatch(row); m != nil {
+				if m[1] == "38" {
+					fg = colors[m[2]]
+				} else {
+					bg = colors[m[2]]
+				}
+				row = row[len(m[0]):]
+				continue
+			}
+			if !strings.HasPrefix(row, "▀") {
+				t.Fatalf("row %d: unexpected %q", y, row)
+			}
+			row = row[len("▀"):]
+			if fg != pixels[2*y*width+cells] || bg != pixels[(2*y+1)*width+cells] {
+				t.Errorf("cell %d,%d is %d/%d, want %d/%d", cells, y, fg, bg, pixels[2*y*width+cells], pixels[(2*y+1)*width+cells])
+			}
+			cells++
+		}
+		if cells != width {
+			t.Errorf("row %d has %d cells, want %d", y, cells, width)
+		}
+	}
+}
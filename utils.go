@@ -1,17 +1,117 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
-func (c *cpu) loadGame(path string) {
-	// Load the game into memory at 0x200
+// Largest ROM that fits in memory after 0x200
+const maxROMSize = 4096 - 0x200
+
+// Extensions of the files that can be loaded. A file without extension is
+// loaded as a CHIP-8 ROM when it is given by its path (the ROMs in games/
+// have none), the menu only lists these extensions.
+var romExtensions = map[string]string{
+	".ch8": "CHIP-8 ROM",
+	".sc8": "SUPER-CHIP ROM",
+	".xo8": "XO-CHIP ROM",
+	".8o":  "Octo source",
+	".gif": "Octo cartridge",
+}
+
+// isROMFile reports if the file is listed as a ROM from its extension.
+func isROMFile(path string) bool {
+	_, ok := romExtensions[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// isListedROM reports if the file with this name and contents is listed in
+// the menu, the same in a directory and in an archive. GIFs are listed only
+// when they are Octo cartridges, not the ones recorded with F9.
+func isListedROM(name string, data []byte) bool {
+	if !isROMFile(name) {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(name), ".gif") {
+		_, err := decodeCartridge(bytes.NewReader(data), name)
+		return err == nil
+	}
+	return true
+}
+
+// Extension of the archives of ROMs, see archive.go
+const archiveExtension = ".zip"
+
+// readROM reads a ROM file and checks that it fits in memory. Octo sources
+// are assembled with the octo command line tool, Octo cartridges also return
+// their options (nil for other files).
+func readROM(path string) ([]byte, *cartridge, error) {
+	var data []byte
+	var cart *cartridge
+	var err error
+	if _, _, ok := splitArchivePath(path); ok {
+		data, cart, err = readArchiveROM(path)
+	} else {
+		data, cart, err = readROMFile(path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading game: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("error loading game: %s is empty", path)
+	}
+	if len(data) > maxROMSize {
+		return nil, nil, fmt.Errorf("error loading game: %s is %d bytes, the largest ROM that fits in memory is %d bytes", filepath.Base(path), len(data), maxROMSize)
+	}
+	return data, cart, nil
+}
+
+// readROMFile reads a file of any of the romExtensions.
+func readROMFile(path string) ([]byte, *cartridge, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".8o":
+		data, err := assembleOcto(path)
+		return data, nil, err
+	case ".gif":
+		cart, err := readCartridge(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := cart.rom(path)
+		return data, cart, err
+	}
 	data, err := os.ReadFile(path)
+	return data, nil, err
+}
+
+// assembleOcto turns an Octo source into a ROM with the octo command line
+// tool (https://github.com/JohnEarnest/c-octo).
+func assembleOcto(path string) ([]byte, error) {
+	octo, err := exec.LookPath("octo")
 	if err != nil {
-		fmt.Printf("Error loading game: %v\n", err)
-		return
+		return nil, fmt.Errorf("%s is an Octo source and the octo command line tool is not installed", filepath.Base(path))
 	}
+	out, err := os.CreateTemp("", "c8-*.ch8")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	if msg, err := exec.Command(octo, path, out.Name()).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("octo could not assemble %s: %s", filepath.Base(path), strings.TrimSpace(string(msg)))
+	}
+	return os.ReadFile(out.Name())
+}
+
+// loadGame loads the game into memory, readROM and the patches already
+// checked that it fits.
+func (c *cpu) loadGame(data []byte) {
+	// Load the game into memory at 0x200
 	for i := 0; i < len(data); i++ {
 		c.memory[i+512] = data[i]
 	}
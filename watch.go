@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha1"
+	"os"
+	"time"
+)
+
+/*
+Watch mode.
+
+With -watch the loaded ROM (the .8o source, or the archive that has it) is
+checked twice per second and the game is reloaded when the file changes, to
+see the result of every build without restarting the emulator. The
+modification time and size are checked first and the content only when they
+change, so saving the same file again doesn't restart the game.
+*/
+
+const watchInterval = 500 * time.Millisecond
+
+type romWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+	sum     [sha1.Size]byte
+	next    time.Time // Time of the next check
+}
+
+func newROMWatcher(path string) *romWatcher {
+	w := &romWatcher{path: path}
+	w.changed(time.Time{})
+	return w
+}
+
+// changed reports if the file has a different content than the last time
+// it was checked.
+func (w *romWatcher) changed(now time.Time) bool {
+	if now.Before(w.next) {
+		return false
+	}
+	w.next = now.Add(watchInterval)
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		// Editors often delete and write the file again, wait for it
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return false
+	}
+	sum := sha1.Sum(data)
+	if sum == w.sum {
+		return false
+	}
+	w.sum = sum
+	return true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+/*
+Window size handling.
+
+The emulator screen keeps its 2:1 aspect ratio inside the window, the rest of
+the framebuffer is left black (letterboxing). Sizes are taken from the
+framebuffer and not from the window, on HiDPI screens they are not the same.
+*/
+
+type scalingMode int
+
+const (
+	scalingInteger scalingMode = iota // Largest whole multiple of 64x32 that fits
+	scalingAspect                     // Fill the window keeping the aspect ratio
+)
+
+var scalingModes = map[string]scalingMode{
+	"integer": scalingInteger,
+	"aspect":  scalingAspect,
+}
+
+type viewport struct {
+	x, y          int32
+	width, height int32
+}
+
+type windowState struct {
+	window  *glfw.Window
+	scaling scalingMode
+	monitor int  // Index in glfw.GetMonitors() used for fullscreen
+	keypad  bool // Leave room for the keypad under the screen
+
+	fbWidth  int
+	fbHeight int
+
+	// Position and size restored when leaving fullscreen
+	windowedX, windowedY          int
+	windowedWidth, windowedHeight int
+}
+
+func newWindowState(window *glfw.Window, scaling scalingMode, monitor int) *windowState {
+	ws := &windowState{window: window, scaling: scaling, monitor: monitor}
+	ws.fbWidth, ws.fbHeight = window.GetFramebufferSize()
+	window.SetFramebufferSizeCallback(func(w *glfw.Window, width, height int) {
+		ws.fbWidth, ws.fbHeight = width, height
+		ws.applyViewport()
+	})
+	return ws
+}
+
+// viewport returns the centered area of the framebuffer used by the emulator
+// screen. With the keypad the screen is centered in the space above it.
+func (ws *windowState) viewport() viewport {
+	w, h := ws.fbWidth, ws.fbHeight
+	below := 0
+	if ws.keypad {
+		below = h - h*keypadScreenShare/100
+		h -= below
+	}
+	width, height := 0, 0
+
+	if scale := min(w/WIDTH, h/HEIGHT); ws.scaling == scalingInteger && scale >= 1 {
+		width, height = WIDTH*scale, HEIGHT*scale
+	} else {
+		// Aspect-correct scaling, also used when the window is smaller than 64x32
+		width, height = w, w*HEIGHT/WIDTH
+		if height > h {
+			width, height = h*WIDTH/HEIGHT, h
+		}
+	}
+
+	return viewport{
+		x:      int32((w - width) / 2),
+		y:      int32(below + (h-height)/2),
+		width:  int32(width),
+		height: int32(height),
+	}
+}
+
+func (ws *windowState) applyViewport() {
+	vp := ws.viewport()
+	gl.Viewport(vp.x, vp.y, vp.width, vp.height)
+}
+
+// clear paints the whole framebuffer black, including the bars around the screen.
+func (ws *windowState) clear() {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Viewport(0, 0, int32(ws.fbWidth), int32(ws.fbHeight))
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	ws.applyViewport()
+}
+
+func (ws *windowState) toggleFullscreen() {
+	if ws.window.GetMonitor() != nil {
+		ws.window.SetMonitor(nil, ws.windowedX, ws.windowedY, ws.windowedWidth, ws.windowedHeight, 0)
+		return
+	}
+
+	ws.windowedX, ws.windowedY = ws.window.GetPos()
+	ws.windowedWidth, ws.windowedHeight = ws.window.GetSize()
+	ws.enterFullscreen()
+}
+
+// nextMonitor moves the fullscreen window to the next connected monitor.
+func (ws *windowState) nextMonitor() {
+	monitors := glfw.GetMonitors()
+	if len(monitors) == 0 {
+		return
+	}
+	ws.monitor = (ws.monitor + 1) % len(monitors)
+	notify("Fullscreen monitor: %d (%s)", ws.monitor, monitors[ws.monitor].GetName())
+	if ws.window.GetMonitor() != nil {
+		ws.enterFullscreen()
+	}
+}
+
+func (ws *windowState) enterFullscreen() {
+	monitor := ws.selectedMonitor()
+	mode := monitor.GetVideoMode()
+	ws.window.SetMonitor(monitor, 0, 0, mode.Width, mode.Height, mode.RefreshRate)
+}
+
+func (ws *windowState) selectedMonitor() *glfw.Monitor {
+	monitors := glfw.GetMonitors()
+	if ws.monitor >= 0 && ws.monitor < len(monitors) {
+		return monitors[ws.monitor]
+	}
+	fmt.Printf("Monitor %d not found, using the primary monitor\n", ws.monitor)
+	return glfw.GetPrimaryMonitor()
+}